@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/DhruvinShiroya/greenlight/internal/audit"
+	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/jsonlog"
+	"github.com/DhruvinShiroya/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// listRolesHandler returns all the roles and the permissions they grant
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserGrantsHandler returns the roles and effective permissions of the user
+func (app *application) showUserGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserGrants(w, r, user)
+}
+
+func (app *application) grantUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddForUser(user.ID, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:   "users",
+		ResourceID: user.ID,
+		Action:     audit.ActionGrant,
+		Attributes: map[string]string{"role": role},
+	})
+
+	app.writeUserGrants(w, r, user)
+}
+
+func (app *application) revokeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(user.ID, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:   "users",
+		ResourceID: user.ID,
+		Action:     audit.ActionRevoke,
+		Attributes: map[string]string{"role": role},
+	})
+
+	app.writeUserGrants(w, r, user)
+}
+
+func (app *application) grantUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code, ok := app.readPermissionParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.AddForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:   "users",
+		ResourceID: user.ID,
+		Action:     audit.ActionGrant,
+		Attributes: map[string]string{"permission": code},
+	})
+
+	app.writeUserGrants(w, r, user)
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code, ok := app.readPermissionParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:   "users",
+		ResourceID: user.ID,
+		Action:     audit.ActionRevoke,
+		Attributes: map[string]string{"permission": code},
+	})
+
+	app.writeUserGrants(w, r, user)
+}
+
+// readUserParam gets the user for the "id" parameter, returns false when the
+// response is already sent
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readRoleParam gets the "role" parameter and checks that it is a known role
+func (app *application) readRoleParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	v := validator.New()
+	if v.Check(validator.In(role, data.RoleViewer, data.RoleEditor, data.RoleAdmin), "role", "invalid role"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return role, true
+}
+
+// readPermissionParam gets the "code" parameter and checks that the permission exists
+func (app *application) readPermissionParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return "", false
+	}
+
+	v := validator.New()
+	if v.Check(permissions.Include(code), "code", "invalid permission code"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return code, true
+}
+
+// writeUserGrants sends the current roles and effective permissions of the user
+func (app *application) writeUserGrants(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	env := envelope{
+		"user_id":     user.ID,
+		"roles":       roles,
+		"permissions": permissions,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showLogLevelHandler returns the current minimum log level
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum log level without restarting the server
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if err != nil {
+		v.AddError("level", "must be one of debug, info, warn, error, fatal or off")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	// logged as warn so the change is visible with any level below error
+	app.logger.PrintWarn("log level changed", map[string]any{
+		"previous_level": previous.String(),
+		"level":          level.String(),
+		"request_id":     app.contextGetRequestID(r),
+	})
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource: "log_level",
+		Action:   audit.ActionUpdate,
+		Attributes: map[string]string{
+			"previous_level": previous.String(),
+			"level":          level.String(),
+		},
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
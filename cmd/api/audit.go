@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/DhruvinShiroya/greenlight/internal/audit"
+	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/validator"
+)
+
+var errAuditCorrupted = errors.New("audit log has corrupted records")
+
+// recordAudit appends the audit event with the request id and client ip of the
+// request. the change is already saved when this is called, so the failure is
+// logged instead of failing the request
+func (app *application) recordAudit(r *http.Request, actorID int64, event audit.Event) {
+	event.ActorID = actorID
+	event.RequestID = app.contextGetRequestID(r)
+	event.ClientIP = app.contextGetClientIP(r)
+
+	_, err := app.audit.Record(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// recordTokenAudit records the creation of the token for the user
+func (app *application) recordTokenAudit(r *http.Request, actorID int64, token *data.Token) {
+	app.recordAudit(r, actorID, audit.Event{
+		Resource:   "tokens",
+		ResourceID: token.UserID,
+		Action:     audit.ActionCreate,
+		Attributes: map[string]string{"scope": token.Scope},
+	})
+}
+
+// listAuditHandler pages through the audit events by their offset in the log
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	offset := app.readInt(qs, "offset", 0, v)
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(offset >= 0, "offset", "must be zero or greater")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.audit.List(uint64(offset), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// corrupted records are skipped but they must not go unnoticed
+	if len(metadata.CorruptedOffsets) > 0 {
+		app.logger.PrintError(errAuditCorrupted, map[string]any{
+			"request_id": app.contextGetRequestID(r),
+			"offsets":    metadata.CorruptedOffsets,
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -13,9 +13,31 @@ type contextKey string
 // convert the string "user" user to a usercontext
 const userContextKey = contextKey("user")
 
+// key for the permissions of the user loaded in authenticate middleware
+const permissionsContextKey = contextKey("permissions")
+
+// key for the client ip resolved in realIP middleware
+const clientIPContextKey = contextKey("client_ip")
+
+// key for the id of the request set in requestID middleware
+const requestIDContextKey = contextKey("request_id")
+
+// key for the access log entry which is filled while the request is handled
+const accessLogContextKey = contextKey("access_log")
+
+// accessLogEntry holds the details known only deeper in the middleware chain,
+// like the authenticated user, for the access log line
+type accessLogEntry struct {
+	userID int64
+}
+
 // set usercontext to a given request and provide new request with 
 // user struct addd to the context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+   // record the user for the access log
+   if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+     entry.userID = user.ID
+   }
    ctx := context.WithValue(r.Context(),userContextKey,user)
    return r.WithContext(ctx)
 }
@@ -29,3 +51,45 @@ func (app *application) contextGetUser(r *http.Request) *data.User{
 
   return user 
 }
+
+// set the permissions of the authenticated user to the request context
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// get the permissions from context, ok is false if they were not loaded
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// set the client ip address to the request context
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// get the client ip from context, it is empty if realIP middleware hasn't run yet
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(clientIPContextKey).(string)
+	return ip
+}
+
+// set the request id to the request context
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// get the request id from context, it is empty if requestID middleware hasn't run yet
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// set the access log entry to the request context
+func (app *application) contextSetAccessLog(r *http.Request, entry *accessLogEntry) *http.Request {
+	ctx := context.WithValue(r.Context(), accessLogContextKey, entry)
+	return r.WithContext(ctx)
+}
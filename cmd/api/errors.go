@@ -3,13 +3,23 @@ package main
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/DhruvinShiroya/greenlight/internal/jsonlog"
 )
 
 func (app *application) logError(r *http.Request, err error) {
+	app.logErrorDepth(1, r, err)
+}
+
+// logErrorDepth is logError for the helpers of this file, depth is the number of
+// frames above the caller to skip so the caller field shows where the error happened
+func (app *application) logErrorDepth(depth int, r *http.Request, err error) {
 	// print errors to new logger with additional information about the method and url
-	app.logger.PrintError(err, map[string]string{
+	app.logger.PrintErrorDepth(depth+1, err, map[string]any{
+		"request_id":     app.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"client_ip":      app.contextGetClientIP(r),
 	})
 }
 
@@ -30,7 +40,8 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // errorResponse() helper to send 500 internal server error status code and JSON response
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logError(r, err)
+	// mark the error as unexpected so the log entry gets the stack trace
+	app.logErrorDepth(1, r, jsonlog.Unexpected(err))
 
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
@@ -65,6 +76,11 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last retrieved it"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -90,3 +106,10 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
   message := "you account doesn't have necessary permissions to access this resource"
   app.errorResponse(w,r,http.StatusForbidden, message)
 }
+
+// rateLimiterUnavailableResponse is sent when the rate limiter backend fails and the
+// limiter is configured to fail closed
+func (app *application) rateLimiterUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the service is temporarily unavailable, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DhruvinShiroya/greenlight/internal/events"
+	"github.com/julienschmidt/httprouter"
+)
+
+// publishMovieEvent publishes the change of the movie to the event stream, the
+// change is already saved so the failure is only logged
+func (app *application) publishMovieEvent(r *http.Request, eventType string, data any) {
+	_, err := app.events.Publish(eventType, data)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// showMovieOrEventsHandler handles "GET /v1/movies/:id". httprouter doesn't allow
+// the static /v1/movies/events route next to /v1/movies/:id, so the event stream is
+// dispatched from here
+func (app *application) showMovieOrEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "events" {
+		app.movieEventsHandler(w, r)
+		return
+	}
+	app.showMovieHandler(w, r)
+}
+
+// movieEventsHandler streams the movie change events with Server-Sent Events. the
+// client which sends Last-Event-ID gets the events after that id from the commit
+// log first, when the events are persisted. without the log the ids start again
+// on restart, so Last-Event-ID is ignored and only the new events are sent
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	resume := false
+	if header := r.Header.Get("Last-Event-ID"); header != "" && app.events.Persistent() {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid Last-Event-ID header"))
+			return
+		}
+		lastID, resume = id, true
+	}
+
+	// the stream stays open longer than the write timeout of the server
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event events.Event) error {
+		if resume && event.ID <= lastID {
+			return nil
+		}
+		js, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, js)
+		if err != nil {
+			return err
+		}
+		lastID, resume = event.ID, true
+		return rc.Flush()
+	}
+
+	// catch up from the log before subscribing, the long replay would fill the
+	// buffer of the subscription and it would be closed. the log is read again
+	// until the replay finds nothing new
+	for resume {
+		before := lastID
+		err = app.events.Replay(lastID, send)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		if lastID == before {
+			break
+		}
+	}
+
+	// the events published after the last replay and before the subscription are
+	// replayed once more, the ones which also arrive from the subscription are
+	// skipped by send
+	sub := app.events.Subscribe()
+	defer sub.Close()
+
+	if resume {
+		err = app.events.Replay(lastID, send)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+	// send the headers even if there is nothing to replay
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	// comment lines keep the connection open through the proxies
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		// the channel is closed on shutdown or if the client fell behind, the
+		// client reconnects with Last-Event-ID
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
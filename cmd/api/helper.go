@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/jsonlog"
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -30,6 +33,49 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readExpectedVersion returns the version the client expects the resource to have,
+// it is read from the X-Expected-Version header. ok is false if the client didn't send it
+func (app *application) readExpectedVersion(r *http.Request) (version int32, ok bool, err error) {
+	s := r.Header.Get("X-Expected-Version")
+	if s == "" {
+		return 0, false, nil
+	}
+
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || v < 1 {
+		return 0, false, errors.New("invalid X-Expected-Version header")
+	}
+	return int32(v), true, nil
+}
+
+// movieETag returns the strong ETag of the movie, it changes whenever the version
+// of the movie is incremented
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d"`, movie.ID, movie.Version)
+}
+
+// etagMatch reports whether the ETag matches any of the ETags in the If-Match or
+// If-None-Match header value. with weak comparison the W/ prefix is ignored, with
+// strong comparison weak ETags never match
+func etagMatch(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, headers http.Header) error {
 	// pass the go object (data) to the json.Marshal() function return []bytes slice
 	// using json.MarshalIndent will result in 65% longer to run and 30% more memory
@@ -126,7 +172,8 @@ func (app *application) background(fn func()) {
 		defer app.wg.Done()
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				// mark the error as unexpected so the log entry gets the stack trace
+				app.logger.PrintError(jsonlog.Unexpected(fmt.Errorf("%s", err)), nil)
 			}
 		}()
 
@@ -173,3 +220,87 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// clientIP returns the ip address of the client. if the remote address is a trusted
+// proxy the forwarded addresses are walked from right to left, skipping the trusted
+// proxies, and the first untrusted address is the client
+func clientIP(remote string, header http.Header, trusted []*net.IPNet) string {
+	ip := net.ParseIP(remote)
+	if ip == nil || !isTrustedProxy(ip, trusted) {
+		return remote
+	}
+
+	client := ip.String()
+	hops := forwardedFor(header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		// stop at the address we can't parse like "unknown", the last
+		// trusted proxy is the best we know
+		if hop == nil {
+			break
+		}
+		client = hop.String()
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+
+	return client
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses from the "for" parameters of the Forwarded
+// header, or from the X-Forwarded-For header if there is no Forwarded header.
+// the addresses are in the order they were added, the closest proxy is last
+func forwardedFor(header http.Header) []string {
+	var hops []string
+
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, forwardedHost(value))
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, forwardedHost(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// forwardedHost removes the quotes, brackets and port from the forwarded address
+// e.g. "[2001:db8::1]:4711" or 192.0.2.60:8080
+func forwardedHost(s string) string {
+	s = strings.Trim(s, `"`)
+
+	if strings.HasPrefix(s, "[") {
+		if end := strings.Index(s, "]"); end > 0 {
+			return s[1:end]
+		}
+		return s
+	}
+
+	// only ipv4 address can have the port without brackets
+	if strings.Count(s, ":") == 1 {
+		host, _, err := net.SplitHostPort(s)
+		if err == nil {
+			return host
+		}
+	}
+	return s
+}
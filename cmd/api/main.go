@@ -2,16 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/DhruvinShiroya/greenlight/internal/audit"
 	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/events"
 	"github.com/DhruvinShiroya/greenlight/internal/jsonlog"
+	commitlog "github.com/DhruvinShiroya/greenlight/internal/log"
 	"github.com/DhruvinShiroya/greenlight/internal/mailer"
+	"github.com/DhruvinShiroya/greenlight/internal/ratelimit"
 	_ "github.com/lib/pq"
 )
 
@@ -20,9 +37,26 @@ const version = "1.0.0"
 
 // config for the http server, properties like port and env
 type Config struct {
-	port int
-	env  string
-	db   struct {
+	port     int
+	env      string
+	logLevel string
+	// values of these log property keys are masked
+	logRedactKeys []string
+	// layout of the log time, which entries get stack trace and whether the caller is logged
+	logTimeFormat string
+	logTrace      string
+	logCaller     bool
+	// entries with same message are sampled after first ones in each interval
+	logSampling jsonlog.Sampling
+	// logs are written to the file instead of stdout when set, it is rotated
+	// by size or daily
+	logFile struct {
+		path       string
+		maxSize    int64
+		daily      bool
+		maxBackups int
+	}
+	db struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -34,6 +68,16 @@ type Config struct {
 		rps    float64
 		burst  int
 		enable bool
+		// memory keeps the limits in the process, postgres shares them between
+		// all instances using the same database
+		backend string
+		// whether the requests are allowed when the backend fails
+		failOpen bool
+		// override of rps and burst for the route path
+		routes map[string]ratelimit.Rule
+		// limit of the requests with Authorization header by ip, checked before
+		// the token is looked up
+		auth ratelimit.Rule
 	}
 	// for mailer
 	smtp struct {
@@ -43,17 +87,54 @@ type Config struct {
 		password string
 		sender   string
 	}
+	// requests from these networks are from our load balancers and proxies, the
+	// client ip is read from their X-Forwarded-For or Forwarded header
+	trustedProxies []*net.IPNet
+	// host and port for the /debug/vars metrics endpoint, 0 port serves it on the
+	// api port for admins only
+	metrics struct {
+		host string
+		port int
+	}
+	// origins which are allowed to make cross origin requests from browser
+	cors struct {
+		trustedOrigins []string
+	}
+	// how long the permissions of user are cached in memory, revokes on other
+	// replicas are only seen after it expires
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	// secret key for signing the pagination cursor
+	cursor struct {
+		secret string
+	}
+	// directory of the audit commit log and how its records are synced, empty
+	// dir disables the audit trail
+	audit struct {
+		dir  string
+		sync string
+	}
+	// movie change events are persisted to the commit log in dir when it is set,
+	// buffer is the number of events held for each stream client
+	events struct {
+		dir    string
+		buffer int
+	}
 }
 
 // define the application struct to hold dependencies for our HTTP handlers , helpers
 // and middleware. at the moment this only contains copy of the config struct and a logger
 // , but it will grow to include a lot more as out build progresses
 type application struct {
-	config Config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  Config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	limiter ratelimit.Limiter
+	audit   *audit.Trail
+	events  *events.Broker
+	wg      sync.WaitGroup
 }
 
 func main() {
@@ -65,6 +146,34 @@ func main() {
 	// flag are provided
 	flag.IntVar(&config.port, "port", 4000, "api server port")
 	flag.StringVar(&config.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&config.logLevel, "log-level", "info", "Minimum log level (debug|info|warn|error|fatal|off)")
+	config.logRedactKeys = jsonlog.DefaultSensitiveKeys
+	flag.Func("log-redact-keys", "Log property keys to mask (space separated)", func(val string) error {
+		config.logRedactKeys = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&config.logTimeFormat, "log-time-format", "rfc3339nano", "Log time format (rfc3339|rfc3339nano|rfc1123|datetime|kitchen or Go time layout)")
+	flag.StringVar(&config.logTrace, "log-trace", "unexpected", "When to include stack trace in logs (never|fatal|unexpected)")
+	flag.BoolVar(&config.logCaller, "log-caller", true, "Include caller file:line in logs")
+	flag.DurationVar(&config.logSampling.Interval, "log-sample-interval", 0, "Log sampling interval, 0 disables sampling")
+	flag.IntVar(&config.logSampling.First, "log-sample-first", 100, "Log entries with same message written in each sampling interval")
+	flag.IntVar(&config.logSampling.Thereafter, "log-sample-thereafter", 100, "After the first entries write only every Nth entry with same message")
+	config.logSampling.Levels = jsonlog.DefaultSampledLevels
+	flag.Func("log-sample-levels", "Log levels to sample (space separated, default \"warn error\")", func(val string) error {
+		config.logSampling.Levels = nil
+		for _, name := range strings.Fields(val) {
+			level, err := jsonlog.ParseLevel(name)
+			if err != nil {
+				return err
+			}
+			config.logSampling.Levels = append(config.logSampling.Levels, level)
+		}
+		return nil
+	})
+	flag.StringVar(&config.logFile.path, "log-file", "", "Write logs to this file instead of stdout")
+	flag.Int64Var(&config.logFile.maxSize, "log-file-max-size", 100, "Rotate the log file at this size in megabytes, 0 disables it")
+	flag.BoolVar(&config.logFile.daily, "log-file-daily", false, "Rotate the log file daily")
+	flag.IntVar(&config.logFile.maxBackups, "log-file-max-backups", 7, "Number of compressed log file backups to keep, 0 keeps all")
 	// Read the DSN value from the db-dsn command-line flag into the config struct.
 	flag.StringVar(&config.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
 	// Read the db connection pool maxOpenConns, maxIdleConns , maxIdleTime
@@ -76,6 +185,39 @@ func main() {
 	flag.Float64Var(&config.limiter.rps, "limiter-rps", 4, "Rate limiter maximum request per second")
 	flag.IntVar(&config.limiter.burst, "limiter-burst", 8, "Rate limiter maximum burst request")
 	flag.BoolVar(&config.limiter.enable, "limiter-enable", true, "Enable rate limiter")
+	flag.StringVar(&config.limiter.backend, "limiter-backend", "memory", "Rate limiter backend (memory|postgres)")
+	flag.BoolVar(&config.limiter.failOpen, "limiter-fail-open", true, "Allow requests when the rate limiter backend fails, false rejects them with 503")
+	flag.Float64Var(&config.limiter.auth.RPS, "limiter-auth-rps", 20, "Rate limiter maximum request per second with Authorization header per ip")
+	flag.IntVar(&config.limiter.auth.Burst, "limiter-auth-burst", 40, "Rate limiter maximum burst request with Authorization header per ip")
+	// authentication is limited more to slow down credential stuffing, the flag can be repeated
+	// for each route as "-limiter-route=/v1/tokens/authentication=0.2:5"
+	config.limiter.routes = map[string]ratelimit.Rule{
+		"/v1/tokens/authentication": {RPS: 0.2, Burst: 5},
+	}
+	flag.Func("limiter-route", "Rate limiter override for route as path=rps:burst (can be repeated)", func(val string) error {
+		path, rule, found := strings.Cut(val, "=")
+		if !found {
+			return errors.New("must be in format path=rps:burst")
+		}
+		rps, burst, found := strings.Cut(rule, ":")
+		if !found {
+			return errors.New("must be in format path=rps:burst")
+		}
+
+		var err error
+		var r ratelimit.Rule
+		r.RPS, err = strconv.ParseFloat(rps, 64)
+		if err != nil || r.RPS <= 0 {
+			return errors.New("rps must be a positive number")
+		}
+		r.Burst, err = strconv.Atoi(burst)
+		if err != nil || r.Burst <= 0 {
+			return errors.New("burst must be a positive integer")
+		}
+
+		config.limiter.routes[path] = r
+		return nil
+	})
 
 	// mailtrap credential for testing and user activation
 	flag.StringVar(&config.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
@@ -83,6 +225,51 @@ func main() {
 	flag.StringVar(&config.smtp.username, "smtp-username", "3df551409fadad", "SMTP username")
 	flag.StringVar(&config.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&config.smtp.sender, "smtp-sender", "Greenlight <no-reply@grd8672aa2264bb5eenlight.DhruvinShiroya.net>", "SMTP sender")
+	// metrics can be served on a separate port which is not exposed publicly
+	flag.IntVar(&config.metrics.port, "metrics-port", 0, "Metrics server port (0 to serve on api port for admins)")
+	flag.StringVar(&config.metrics.host, "metrics-host", "127.0.0.1", "Metrics server host, the metrics server has no authentication")
+
+	// trusted origins as space separated list e.g. "https://greenlight.example.com http://localhost:9000"
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		config.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// trusted proxies as space separated list of CIDR, single ip is also accepted
+	flag.Func("trusted-proxies", "Trusted proxy CIDRs (space separated)", func(val string) error {
+		for _, cidr := range strings.Fields(val) {
+			// convert single ip to the network with only that ip
+			if !strings.Contains(cidr, "/") {
+				ip := net.ParseIP(cidr)
+				if ip == nil {
+					return fmt.Errorf("invalid ip address %q", cidr)
+				}
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+				cidr = fmt.Sprintf("%s/%d", cidr, bits)
+			}
+
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return err
+			}
+			config.trustedProxies = append(config.trustedProxies, network)
+		}
+		return nil
+	})
+
+	// permissions are cached for 10 seconds by default, 0 disables the cache
+	flag.DurationVar(&config.permissions.cacheTTL, "permissions-cache-ttl", 10*time.Second, "Permission cache TTL, other replicas keep revoked permissions for up to this long (0 to disable)")
+
+	// cursor secret should be same on all the instances so the cursor issued by one
+	// instance can be used on another
+	flag.StringVar(&config.cursor.secret, "cursor-secret", os.Getenv("GREENLIGHT_CURSOR_SECRET"), "Secret key for signing pagination cursor")
+	flag.StringVar(&config.audit.dir, "audit-dir", "audit", "Directory of the audit log, empty disables the audit trail")
+	flag.StringVar(&config.audit.sync, "audit-sync", "always", "When audit records are synced to disk (os|always|group)")
+	flag.StringVar(&config.events.dir, "events-dir", "", "Directory to persist the movie events in, empty keeps them in memory only")
+	flag.IntVar(&config.events.buffer, "events-buffer", 64, "Number of movie events buffered for each stream client")
 	flag.Parse()
 
 	// initialize the new logger which writes to the out stream
@@ -91,7 +278,62 @@ func main() {
 
 	// initialze new json logger which writes any message (at or above)
 	// severity level to the standard out stream
-	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
+	level, err := jsonlog.ParseLevel(config.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tracePolicy, err := jsonlog.ParseTracePolicy(config.logTrace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// write to the log file if set, it is reopened on SIGUSR1 so logrotate can move it
+	var out io.Writer = os.Stdout
+	if config.logFile.path != "" {
+		file, err := jsonlog.OpenFile(config.logFile.path, jsonlog.FileOptions{
+			MaxSize:    config.logFile.maxSize * 1024 * 1024,
+			Daily:      config.logFile.daily,
+			MaxBackups: config.logFile.maxBackups,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+
+		reopen := make(chan os.Signal, 1)
+		signal.Notify(reopen, syscall.SIGUSR1)
+		go func() {
+			for range reopen {
+				if err := file.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "reopen log file: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	logger := jsonlog.NewLogger(out, level)
+	logger.SetSensitiveKeys(config.logRedactKeys...)
+	logger.SetTimeFormat(jsonlog.ParseTimeFormat(config.logTimeFormat))
+	logger.SetTracePolicy(tracePolicy)
+	logger.SetCaller(config.logCaller)
+	logger.SetSampling(config.logSampling)
+	// report suppressed log entries before exit
+	defer logger.Close()
+
+	// packages using log/slog write through our json logger as well
+	slog.SetDefault(slog.New(jsonlog.NewSlogHandler(logger)))
+
+	// if cursor secret is not provided generate random one, cursors will not be
+	// valid after restart of the server
+	if config.cursor.secret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		config.cursor.secret = hex.EncodeToString(secret)
+		logger.PrintInfo("cursor secret is not set, using random secret", nil)
+	}
 
 	// call openDB() function to create connection pool
 	db, err := openDb(config)
@@ -106,13 +348,73 @@ func main() {
 	// update information to the new json logger
 	logger.PrintInfo("database connection is established", nil)
 
+	// create the rate limiter backend, clients not seen for three minutes are
+	// removed every minute
+	var limiter ratelimit.Limiter
+	switch config.limiter.backend {
+	case "memory":
+		limiter = ratelimit.NewMemoryLimiter(time.Minute, 3*time.Minute)
+	case "postgres":
+		limiter = ratelimit.NewPostgresLimiter(db, time.Minute, 3*time.Minute)
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid limiter backend %q", config.limiter.backend), nil)
+	}
+
+	// audit events are appended to the commit log in the audit directory
+	var trail *audit.Trail
+	if config.audit.dir != "" {
+		trail, err = openAudit(config)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer trail.Close()
+		// the torn record of the crash is dropped, report it so it is not silent
+		if n := trail.DroppedBytes(); n > 0 {
+			logger.PrintWarn("truncated torn audit records", map[string]any{
+				"dir":           config.audit.dir,
+				"dropped_bytes": n,
+			})
+		}
+	}
+
+	// movie events are sent to the stream clients and persisted if the dir is set
+	broker, eventLog, err := openEvents(config)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if eventLog != nil {
+		defer eventLog.Close()
+		if n := eventLog.DroppedBytes(); n > 0 {
+			logger.PrintWarn("truncated torn movie events", map[string]any{
+				"dir":           config.events.dir,
+				"dropped_bytes": n,
+			})
+		}
+	}
+
+	// publish the application information and runtime metrics for /debug/vars
+	expvar.NewString("version").Set(version)
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	// statistics of the connection pool configured in openDb()
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+	expvar.Publish("timestamp", expvar.Func(func() any {
+		return time.Now().Unix()
+	}))
+
 	// declare the instance of the application struct
 	// provide the config and logger instance
 	app := &application{
-		config: config,
-		logger: logger,
-		models: data.NewModel(db),
-		mailer: mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender),
+		config:  config,
+		logger:  logger,
+		models:  data.NewModel(db, config.permissions.cacheTTL),
+		mailer:  mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender),
+		limiter: limiter,
+		audit:   trail,
+		events:  broker,
 	}
 
 	// starts the HTTP server
@@ -122,6 +424,51 @@ func main() {
 	}
 }
 
+// openAudit creates the audit directory and opens the trail in it
+func openAudit(cfg Config) (*audit.Trail, error) {
+	policy, err := commitlog.ParseSyncPolicy(cfg.audit.sync)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(cfg.audit.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var c commitlog.Config
+	c.Store.Sync = policy
+	c.Store.WaitForSync = true
+
+	return audit.New(cfg.audit.dir, c)
+}
+
+// openEvents creates the broker, the events are appended to the commit log when
+// the events dir is set. the log is returned so it can be closed on exit
+func openEvents(cfg Config) (*events.Broker, *commitlog.Log, error) {
+	if cfg.events.dir == "" {
+		broker, err := events.NewBroker(nil, cfg.events.buffer)
+		return broker, nil, err
+	}
+
+	err := os.MkdirAll(cfg.events.dir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := commitlog.NewLog(cfg.events.dir, commitlog.Config{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	broker, err := events.NewBroker(l, cfg.events.buffer)
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return broker, l, nil
+}
+
 func openDb(cfg Config) (*sql.DB, error) {
 	// use sql.Open() to create connection pool
 	db, err := sql.Open("postgres", cfg.db.dsn)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// upper bounds of the latency histogram buckets in milliseconds
+var latencyBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// metricsResponseWriter wraps the http.ResponseWriter to record the status code
+// and the number of bytes written for the response
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	bytes         int
+}
+
+func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
+	return &metricsResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+	n, err := mw.wrapped.Write(b)
+	mw.bytes += n
+	return n, err
+}
+
+// Unwrap returns the original response writer so http.ResponseController can
+// reach its Flush and deadline methods
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// histogram counts the observations in the latency buckets, the last count is
+// for the observations greater than the largest bucket
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// routeLatency keeps a latency histogram for every route pattern
+type routeLatency struct {
+	mu     sync.Mutex
+	routes map[string]*histogram
+}
+
+func newRouteLatency() *routeLatency {
+	return &routeLatency{routes: make(map[string]*histogram)}
+}
+
+func (rl *routeLatency) observe(route string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	h, found := rl.routes[route]
+	if !found {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		rl.routes[route] = h
+	}
+
+	// index of first bucket the observation fits in, or the last index for +Inf
+	i := sort.SearchFloat64s(latencyBuckets, ms)
+	h.counts[i]++
+	h.count++
+	h.sum += ms
+}
+
+// snapshot returns the histograms with cumulative bucket counts like prometheus,
+// it is published with expvar so it must be safe to call at any time
+func (rl *routeLatency) snapshot() any {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	out := make(map[string]any, len(rl.routes))
+	for route, h := range rl.routes {
+		buckets := make(map[string]int64, len(h.counts))
+		var cumulative int64
+		for i, c := range h.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'f', -1, 64)
+			}
+			buckets[le] = cumulative
+		}
+
+		out[route] = map[string]any{
+			"buckets": buckets,
+			"count":   h.count,
+			"sum_ms":  h.sum,
+		}
+	}
+	return out
+}
+
+// routePattern returns the method and the pattern of the route matching the request,
+// like "GET /v1/movies/:id", so metrics for all ids are counted together
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	// parameters are whole segments in our routes, replace them from the right
+	// so a parameter value equal to a static segment is not replaced
+	segments := strings.Split(r.URL.Path, "/")
+	j := len(segments) - 1
+	for i := len(params) - 1; i >= 0; i-- {
+		for ; j >= 0; j-- {
+			if segments[j] == params[i].Value {
+				segments[j] = ":" + params[i].Key
+				j--
+				break
+			}
+		}
+	}
+
+	return r.Method + " " + strings.Join(segments, "/")
+}
+
+// metricsHandler serves the expvar variables like expvar.Handler but without the
+// cmdline variable, the command line has the passwords and secrets from the flags
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		fmt.Fprintf(w, "{\n")
+		first := true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if kv.Key == "cmdline" {
+				return
+			}
+			if !first {
+				fmt.Fprintf(w, ",\n")
+			}
+			first = false
+			fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+		})
+		fmt.Fprintf(w, "\n}\n")
+	})
+}
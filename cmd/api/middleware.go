@@ -1,17 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"expvar"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/ratelimit"
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
-	"golang.org/x/time/rate"
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -32,80 +37,218 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler { // define client struct which will hold rate limiter and last seen time
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// requestID uses the X-Request-ID sent by the client or the proxy, or generate a
+// new one, and stores it in request context and the response header
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID only accepts ids which are safe to write to logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
 	}
-	// declare a mutex and amp to hold client ip address from the request
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// logAccess writes one log line for every request after it is handled
+func (app *application) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// user is set deeper in the chain by authenticate, it is recorded in the entry
+		entry := &accessLogEntry{}
+		r = app.contextSetAccessLog(r, entry)
 
-	// launch a background go routine which removes old entries from the
-	// client map every one minute
-	go func() {
-		time.Sleep(time.Minute)
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
 
-		// lock mutex for inactive client clean up
-		mu.Lock()
+		properties := map[string]any{
+			"request_id":     app.contextGetRequestID(r),
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"status":         mw.statusCode,
+			"bytes":          mw.bytes,
+			"duration":       time.Since(start),
+			"client_ip":      app.contextGetClientIP(r),
+		}
+		if entry.userID != 0 {
+			properties["user_id"] = entry.userID
+		}
+
+		app.logger.PrintInfo("request completed", properties)
+	})
+}
 
-		// loop though all client , if they haven't been seen within the last five minutes
-		// delete corresponding entry from clients map
-		for ip, client := range clients {
-			if time.Since(client.lastSeen) > 3*time.Minute {
-				delete(clients, ip)
+// enableCORS allows the trusted origins to make cross origin requests, the origin
+// is echoed back only if it matches one of the trusted origins and the preflight
+// requests are answered here without reaching the router
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// response is different for each origin so caches must vary on it
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			for _, trusted := range app.config.cors.trustedOrigins {
+				if origin != trusted {
+					continue
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// allow the browser to read our custom headers
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, Resource-Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Request-ID")
+
+				// preflight request has OPTIONS method and Access-Control-Request-Method header
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, X-Expected-Version, X-Request-ID")
+					// preflight response can be cached by the browser for an hour
+					w.Header().Set("Access-Control-Max-Age", "3600")
+
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				break
 			}
 		}
-		// always unlock mutex after clean up or application will halt
-		mu.Unlock()
-	}()
 
+		next.ServeHTTP(w, r)
+	})
+}
+
+// realIP resolves the ip address of the client and stores it in request context.
+// when the request comes from trusted proxy the address is taken from the
+// Forwarded or X-Forwarded-For header, otherwise the remote address is used
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// extract the remote ip address from request
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		r = app.contextSetClientIP(r, clientIP(remote, r.Header, app.config.trustedProxies))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// only carry out the ratelimit if enabled
 		if app.config.limiter.enable {
+			// use the override for the route if there is one, each route with
+			// override has its own limiters so it doesn't share the quota of other routes
+			rule := ratelimit.Rule{RPS: app.config.limiter.rps, Burst: app.config.limiter.burst}
+			class := "default"
+			if override, found := app.config.limiter.routes[r.URL.Path]; found {
+				rule = override
+				class = r.URL.Path
+			}
 
-			// extract client ip address from request
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
+			// authenticated user is limited by user id so the users behind same ip
+			// don't share the limit, anonymous request fall back to ip address
+			var key string
+			user := app.contextGetUser(r)
+			if !user.IsAnonymous() {
+				key = fmt.Sprintf("%s|user:%d", class, user.ID)
+			} else {
+				// client ip address is resolved by realIP middleware
+				key = fmt.Sprintf("%s|ip:%s", class, app.contextGetClientIP(r))
 			}
-			// lock mutex
-			mu.Lock()
-			// check if the ip address already exists in the map. if not present
-			// add it client map with new rateLimiter
-			if _, found := clients[ip]; !found {
-				// create a limiter which allows control over request per second
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
+
+			if !app.allow(w, r, key, rule) {
+				return
 			}
-			// update the lastseen for client
-			clients[ip].lastSeen = time.Now()
-
-			// call allow method on the rate limiter for the current ip address
-			// if the request isn't allowed , unlock the mutex and send too many request
-			// error
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitAuth limits the requests with Authorization header by the client ip
+// before authenticate looks the token up in the database, so invalid tokens can't
+// be used to flood the database. the rule is looser than the per user rule
+// because many users can share the ip address
+func (app *application) rateLimitAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enable && r.Header.Get("Authorization") != "" {
+			key := fmt.Sprintf("auth|ip:%s", app.contextGetClientIP(r))
+			if !app.allow(w, r, key, app.config.limiter.auth) {
 				return
 			}
-			// unlock mutex before calling next handler in the chain
-			// otherwise mutex will be locked till all the downstream
-			// of this middleware is also returned
-			mu.Unlock()
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// allow takes a token from the bucket of the key and sets the rate limit headers,
+// it returns false when the too many requests response is already sent
+func (app *application) allow(w http.ResponseWriter, r *http.Request, key string, rule ratelimit.Rule) bool {
+	// call allow method on the limiter backend for the current key. when the backend
+	// fails the request is let through or rejected as configured, so the outage of
+	// the shared backend doesn't have to take the whole api down
+	result, err := app.limiter.Allow(r.Context(), key, rule)
+	if err != nil {
+		app.logError(r, fmt.Errorf("rate limiter: %w", err))
+		if app.config.limiter.failOpen {
+			return true
+		}
+		app.rateLimiterUnavailableResponse(w, r)
+		return false
+	}
+
+	setRateLimitHeaders(w, result)
+
+	// if the request isn't allowed tell the client when to retry and send
+	// too many request error
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// setRateLimitHeaders writes the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, reset is the number of seconds until the bucket is full again
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+// ceilSeconds returns the duration in whole seconds rounded up
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// add header "Vary": "Authorization" response my vary based on authorization header
 
-		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "Authorization")
 		// get the authorization header
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
@@ -140,8 +283,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
-		// set the user to response writer
+		// load the permissions once for the request, they are served from the
+		// permission cache for most of the requests
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// set the user and permissions to request context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetPermissions(r, permissions)
 		next.ServeHTTP(w, r)
 
 	})
@@ -180,12 +332,16 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		// get the user from request
 		user := app.contextGetUser(r)
-		// get the permission slice for user
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		// get the permission slice for user, it is already in the context when
+		// user was authenticated
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 		// status forbidden for users without valid permission
 		if !permissions.Include(code) {
@@ -197,3 +353,42 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	}
 	return app.RequiredActivatedUser(fn)
 }
+
+// metrics records the number of requests and responses by status code, the requests
+// in flight and the latency of each route. the values are published with expvar
+func (app *application) metrics(router *httprouter.Router, next http.Handler) http.Handler {
+	var (
+		totalRequestsReceived           = expvar.NewInt("total_requests_received")
+		totalResponsesSent              = expvar.NewInt("total_responses_sent")
+		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+		inFlightRequests                = expvar.NewInt("in_flight_requests")
+		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+		latency                         = newRouteLatency()
+	)
+	expvar.Publish("request_latency_ms", expvar.Func(latency.snapshot))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		totalRequestsReceived.Add(1)
+		inFlightRequests.Add(1)
+
+		// pattern is looked up before calling the next handler because the
+		// request could be changed down the chain
+		route := routePattern(router, r)
+
+		mw := newMetricsResponseWriter(w)
+		// in flight is decremented even if the handler panics
+		defer func() {
+			inFlightRequests.Add(-1)
+
+			duration := time.Since(start)
+			totalResponsesSent.Add(1)
+			totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+			totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+			latency.observe(route, duration)
+		}()
+
+		next.ServeHTTP(mw, r)
+	})
+}
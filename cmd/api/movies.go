@@ -1,21 +1,22 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/DhruvinShiroya/greenlight/internal/audit"
 	"github.com/DhruvinShiroya/greenlight/internal/data"
+	"github.com/DhruvinShiroya/greenlight/internal/events"
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
 )
 
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title    string
-		Genres   []string
-		Page     int
-		PageSize int
-		Sort     string
+		Title  string
+		Genres []string
+		data.Filter
 	}
 
 	v := validator.New()
@@ -29,19 +30,74 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
-	input.Page = app.readInt(qs, "page", 1, v)
-	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filter.Page = app.readInt(qs, "page", 1, v)
+	input.Filter.PageSize = app.readInt(qs, "page_size", 20, v)
 	// Extract the sort query string value, falling back to "id" if it is not provided
 	// by the client (which will imply a ascending sort on movie ID).
-	input.Sort = app.readString(qs, "sort", "id")
+	input.Filter.Sort = app.readString(qs, "sort", "id")
+	// only these values are allowed for sort, the "-" prefix means descending order
+	input.Filter.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	// cursor from the next_cursor of previous response, it can't be used with page
+	if cursor := app.readString(qs, "cursor", ""); cursor != "" {
+		after, err := data.DecodeCursor(cursor, []byte(app.config.cursor.secret))
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		}
+		v.Check(qs.Get("page") == "", "page", "must not be provided with cursor")
+		input.Filter.After = after
+	}
+
 	// Check the Validator instance for any errors and use the failedValidationResponse()
 	// helper to send the client a response if necessary.
-	if !v.Valid() {
+	if data.ValidateFilter(v, input.Filter); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	// Dump the contents of the input struct in a HTTP response.
-	fmt.Fprintf(w, "%+v\n", input)
+
+	// get the page of movies matching the filters
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// sign the position of the last movie so client can request the next page
+	if metadata.Next != nil {
+		metadata.NextCursor, err = data.EncodeCursor(*metadata.Next, []byte(app.config.cursor.secret))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// weak etag of the page, it changes when any movie in the page or metadata changes
+	etag := listETag(movies, metadata)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatch(match, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header := make(http.Header)
+	header.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, header)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listETag computes the weak ETag from the id and version of the movies in the page
+// and the pagination metadata
+func listETag(movies []*data.Movie, metadata data.Metadata) string {
+	h := sha256.New()
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d-%d;", movie.ID, movie.Version)
+	}
+	fmt.Fprintf(h, "%d;%d;%s", metadata.CurrentPage, metadata.TotalRecord, metadata.NextCursor)
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
 }
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -85,12 +141,21 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:     "movies",
+		ResourceID:   movie.ID,
+		Action:       audit.ActionCreate,
+		VersionAfter: audit.Version(int64(movie.Version)),
+	})
+	app.publishMovieEvent(r, events.MovieCreated, movie)
+
 	// when sending HTTP response , we want to include the location at which
 	// URL new movie is created , how to access it. we will send this information
 	// in our headers with location header
 	// create new header from http library
 	header := make(http.Header)
 	header.Set("Resource-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	header.Set("ETag", movieETag(movie))
 
 	// write json response with 201 resource created status code
 	// send movie data in response body
@@ -123,7 +188,18 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// client already has the current version of the movie
+	etag := movieETag(movie)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatch(match, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header := make(http.Header)
+	header.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -134,6 +210,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	movie, err := app.models.Movies.Get(id)
@@ -141,10 +218,15 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-			return
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	// if client sent the version it expects, check it before reading the payload
+	if !app.checkExpectedVersion(w, r, movie) {
+		return
 	}
 
 	// create varible payload to handle the incoming payload from request body
@@ -167,9 +249,89 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	movie.Year = payload.Year
 	movie.Runtime = payload.Runtime
 	movie.Genres = payload.Genres
-	// update movie version with each update
-	// movie.Version = movie.Version + 1
 
+	app.saveMovie(w, r, movie)
+}
+
+// partialUpdateMovieHandler handles "PATCH /v1/movies/:id", only the fields present
+// in the payload are updated and the others are left as they are
+func (app *application) partialUpdateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkExpectedVersion(w, r, movie) {
+		return
+	}
+
+	// pointer fields are nil when the key is not present in the payload
+	var payload struct {
+		Title   *string       `json:"title"`
+		Year    *int32        `json:"year"`
+		Runtime *data.Runtime `json:"runtime"`
+		Genres  []string      `json:"genres"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Title != nil {
+		movie.Title = *payload.Title
+	}
+	if payload.Year != nil {
+		movie.Year = *payload.Year
+	}
+	if payload.Runtime != nil {
+		movie.Runtime = *payload.Runtime
+	}
+	// slice is already nil if genres is not provided
+	if payload.Genres != nil {
+		movie.Genres = payload.Genres
+	}
+
+	app.saveMovie(w, r, movie)
+}
+
+// checkExpectedVersion checks the If-Match and X-Expected-Version headers against the
+// current version of the movie, returns false when the response is already sent
+func (app *application) checkExpectedVersion(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	// stale If-Match etag means the precondition failed
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatch(match, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	version, ok, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+	if ok && version != movie.Version {
+		app.editConflictResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// saveMovie validates the updated movie and writes it to database, the update only
+// succeeds if nobody changed the movie since it was read
+func (app *application) saveMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
 	// validate updated movie and if fails return error  422 unprocessable entity
 	v := validator.New()
 	data.ValidateMovie(v, movie)
@@ -180,12 +342,34 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	// add the new movie to database
-	err = app.models.Movies.Update(movie)
+	before := movie.Version
+	err := app.models.Movies.Update(movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		// the movie changed after the If-Match check, the precondition failed
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	// return updated movie
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:      "movies",
+		ResourceID:    movie.ID,
+		Action:        audit.ActionUpdate,
+		VersionBefore: audit.Version(int64(before)),
+		VersionAfter:  audit.Version(int64(movie.Version)),
+	})
+	app.publishMovieEvent(r, events.MovieUpdated, movie)
+	// return updated movie with the new etag
+	header := make(http.Header)
+	header.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -199,19 +383,48 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// delete the movie with id, if movie with id doesn't exist then
-	// return record not found
-	if err := app.models.Movies.Delete(id); err != nil {
+	// the movie is read first so the deleted version is known for the audit trail
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-			return
 		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
 	}
 
+	// with If-Match header the movie is only deleted if client has the current version
+	if !app.checkExpectedVersion(w, r, movie) {
+		return
+	}
+
+	// delete the movie only if it still has the version which was checked, the
+	// movie changed in between fails the If-Match precondition
+	if err := app.models.Movies.Delete(id, movie.Version); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, app.contextGetUser(r).ID, audit.Event{
+		Resource:      "movies",
+		ResourceID:    movie.ID,
+		Action:        audit.ActionDelete,
+		VersionBefore: audit.Version(int64(movie.Version)),
+	})
+	app.publishMovieEvent(r, events.MovieDeleted, map[string]any{
+		"id":      movie.ID,
+		"version": movie.Version,
+	})
+
 	msg := fmt.Sprintf("movie id : %d deleted successfully", id)
 	// upon successful movie delete return 200
 	err = app.writeJSON(w, http.StatusOK, envelope{"msg": msg}, nil)
@@ -21,16 +21,46 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	// also serves the movie change events on /v1/movies/events
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieOrEventsHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.partialUpdateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
 	// register user routes
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
 	// authenticate user
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+
+	// admin routes for managing roles and permissions of users
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.showUserGrantsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.grantUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.revokeUserRoleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.grantUserPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+
+	// audit trail of the changes made through the api
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("users:admin", app.listAuditHandler))
 
 	// return the httprouter instance
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	// change the log level at runtime
+	router.HandlerFunc(http.MethodGet, "/v1/admin/log-level", app.requirePermission("users:admin", app.showLogLevelHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/log-level", app.requirePermission("users:admin", app.updateLogLevelHandler))
+
+	// expose the metrics on the api port when there is no separate metrics port,
+	// only to the admins because the api port is public
+	if app.config.metrics.port == 0 {
+		router.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("users:admin", metricsHandler().ServeHTTP))
+	}
+
+	// request id and client ip are set first so they are available for all the logs,
+	// access log is written after the panic is recovered, rate limit runs after
+	// authenticate so it can limit by the user. requests with token are limited by
+	// ip before authenticate so invalid tokens don't reach the database unlimited
+	return app.metrics(router, app.requestID(app.realIP(app.logAccess(app.recoverPanic(app.enableCORS(app.rateLimitAuth(app.authenticate(app.rateLimit(router)))))))))
 }
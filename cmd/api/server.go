@@ -4,27 +4,68 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/DhruvinShiroya/greenlight/internal/jsonlog"
 )
 
 func (app *application) serve() error {
 	// use httprouter instance return from app.routes() as server handler
 	// declare the http server with some timeout setting , which listen on provided port
-	// httpserver has it's own logger which write logs and if we want it to use our custom
-	// ErrorLog logger uncomment the line
+	// httpserver has it's own logger which write logs, it is routed through the slog
+	// handler so the errors are written as our json log entries
 	// declare a HTTP server using settings from main() func
+	errorLog := slog.NewLogLogger(jsonlog.NewSlogHandler(app.logger), slog.LevelError)
+
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
-		Handler: app.routes(),
-		// ErrorLog:     log.New(logger, "", 0),
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		ErrorLog:     errorLog,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	// the event streams never finish on their own, close them so Shutdown
+	// doesn't wait for them
+	srv.RegisterOnShutdown(app.events.Close)
+
+	// metrics server is only started when the metrics port is set
+	var metricsSrv *http.Server
+	if app.config.metrics.port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", metricsHandler())
+
+		// the metrics server has no authentication, it listens on loopback
+		// unless other host is configured
+		metricsSrv = &http.Server{
+			Addr:         net.JoinHostPort(app.config.metrics.host, strconv.Itoa(app.config.metrics.port)),
+			Handler:      mux,
+			ErrorLog:     errorLog,
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+
+		go func() {
+			app.logger.PrintInfo("starting metrics server", map[string]any{
+				"addr": metricsSrv.Addr,
+			})
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]any{
+					"addr": metricsSrv.Addr,
+				})
+			}
+		}()
+	}
+
 	// create shutDownError channle for handling any error returned
 	// from graceful shutdown
 	shutDownError := make(chan error)
@@ -41,7 +82,7 @@ func (app *application) serve() error {
 		s := <-quit
 		// log message to say that signal is caught
 		// get the signal name and include it in the log entry properties
-		app.logger.PrintInfo("shutting down server", map[string]string{
+		app.logger.PrintInfo("shutting down server", map[string]any{
 			"signal": s.String(),
 		})
 		// create context with a 5 second timeout
@@ -53,8 +94,20 @@ func (app *application) serve() error {
 			shutDownError <- err
 		}
 
+		// metrics are not needed once the api server is stopped
+		if metricsSrv != nil {
+			if err := metricsSrv.Shutdown(ctx); err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+
+		// stop the clean up of the rate limiter
+		if err := app.limiter.Close(); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
 		// log message for finishing background goroutines
-		app.logger.PrintInfo("completing background tasks", map[string]string{
+		app.logger.PrintInfo("completing background tasks", map[string]any{
 			"addr": srv.Addr,
 		})
 		app.wg.Wait()
@@ -62,7 +115,7 @@ func (app *application) serve() error {
 	}()
 
 	// starts the HTTP server
-	app.logger.PrintInfo("starting server", map[string]string{
+	app.logger.PrintInfo("starting server", map[string]any{
 		"addr": srv.Addr,
 		"env":  app.config.env,
 	})
@@ -82,7 +135,7 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.PrintInfo("stopped server", map[string]string{
+	app.logger.PrintInfo("stopped server", map[string]any{
 		"addr": srv.Addr,
 	})
 	return nil
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/DhruvinShiroya/greenlight/internal/audit"
 	"github.com/DhruvinShiroya/greenlight/internal/data"
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
 )
@@ -44,8 +45,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// insert data into database
-	err = app.models.Users.Insert(user)
+	// insert data into database, the default role is assigned with it so the new
+	// user can read movies once activated
+	err = app.models.Users.Insert(user, data.DefaultRole)
 	if err != nil {
 		// check for duplicate email if user is already registered
 		switch {
@@ -58,12 +60,22 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// the new user is the actor, nobody is authenticated yet
+	app.recordAudit(r, user.ID, audit.Event{
+		Resource:     "users",
+		ResourceID:   user.ID,
+		Action:       audit.ActionCreate,
+		VersionAfter: audit.Version(int64(user.Version)),
+		Attributes:   map[string]string{"role": data.DefaultRole},
+	})
+
 	// Afterthe user reocrd has been created in the database , genrerate new activation token
 	token, err := app.models.Token.New(user.ID, time.Minute*10, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.recordTokenAudit(r, user.ID, token)
 
 	app.background(func() {
 
@@ -71,10 +83,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
 		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		// send new account id
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 			return
 		}
 	})
@@ -112,6 +124,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// update the user activated
+	before := user.Version
 	user.Activated = true
 
 	//save the update to the database
@@ -132,9 +145,104 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// the holder of the activation token is the actor
+	app.recordAudit(r, user.ID, audit.Event{
+		Resource:      "users",
+		ResourceID:    user.ID,
+		Action:        audit.ActionActivate,
+		VersionBefore: audit.Version(int64(before)),
+		VersionAfter:  audit.Version(int64(user.Version)),
+	})
+
 	//Send the updated user details to the client json response
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// validate new password and token string
+	data.ValidatePassword(v, input.Password)
+	data.ValidateTokenPlainText(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// set the new password hash
+	before := user.Version
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// password reset token is one time use, and the authentication token issued
+	// with the old password must not be valid anymore
+	err = app.models.Token.DeleteAllForUser(user.ID, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Token.DeleteAllForUser(user.ID, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the holder of the password reset token is the actor, the hash is not recorded
+	app.recordAudit(r, user.ID, audit.Event{
+		Resource:      "users",
+		ResourceID:    user.ID,
+		Action:        audit.ActionUpdate,
+		VersionBefore: audit.Version(int64(before)),
+		VersionAfter:  audit.Version(int64(user.Version)),
+		Attributes:    map[string]string{"field": "password"},
+	})
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
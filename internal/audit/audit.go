@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	commitlog "github.com/DhruvinShiroya/greenlight/internal/log"
+)
+
+// actions recorded in the audit trail
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionActivate = "activate"
+	ActionGrant    = "grant"
+	ActionRevoke   = "revoke"
+)
+
+// Event is one mutation made through the api. ActorID is 0 when nobody was
+// authenticated, the versions are nil when the resource has no version before or
+// after the action
+type Event struct {
+	Offset        uint64            `json:"offset"`
+	Time          time.Time         `json:"time"`
+	ActorID       int64             `json:"actor_id"`
+	RequestID     string            `json:"request_id"`
+	ClientIP      string            `json:"client_ip,omitempty"`
+	Resource      string            `json:"resource"`
+	ResourceID    int64             `json:"resource_id"`
+	Action        string            `json:"action"`
+	VersionBefore *int64            `json:"version_before,omitempty"`
+	VersionAfter  *int64            `json:"version_after,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+}
+
+// Version returns the pointer to the version for VersionBefore and VersionAfter
+func Version(v int64) *int64 {
+	return &v
+}
+
+// Metadata is returned with the listed events, NextOffset is the offset to
+// continue from
+type Metadata struct {
+	Offset        uint64 `json:"offset"`
+	NextOffset    uint64 `json:"next_offset"`
+	LowestOffset  uint64 `json:"lowest_offset"`
+	HighestOffset uint64 `json:"highest_offset"`
+	// records which failed the checksum and were skipped
+	CorruptedOffsets []uint64 `json:"corrupted_offsets,omitempty"`
+}
+
+// Trail appends the events to the commit log, the offset of the record in the log
+// is the offset of the event. the nil Trail records nothing
+type Trail struct {
+	log *commitlog.Log
+}
+
+// New opens the trail in the directory
+func New(dir string, c commitlog.Config) (*Trail, error) {
+	l, err := commitlog.NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Trail{log: l}, nil
+}
+
+// Record appends the event and returns its offset, the time is set if it is zero
+func (t *Trail) Record(e Event) (uint64, error) {
+	if t == nil {
+		return 0, nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	// offset is known after the append, it is set when the event is read
+	e.Offset = 0
+	record, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	return t.log.Append(record)
+}
+
+// List returns up to limit events starting at the offset, the offset before the
+// lowest one starts at the lowest offset
+func (t *Trail) List(offset uint64, limit int) ([]Event, Metadata, error) {
+	events := []Event{}
+	metadata := Metadata{Offset: offset, NextOffset: offset}
+	if t == nil {
+		return events, metadata, nil
+	}
+
+	lowest, err := t.log.LowestOffset()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	highest, err := t.log.HighestOffset()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata.LowestOffset = lowest
+	metadata.HighestOffset = highest
+
+	if offset < lowest {
+		offset = lowest
+	}
+	metadata.Offset = offset
+	metadata.NextOffset = offset
+
+	for off := offset; off <= highest && len(events) < limit; off++ {
+		record, err := t.log.Read(off)
+		if err != nil {
+			var corrupted *commitlog.CorruptionError
+			switch {
+			// the log is empty
+			case errors.Is(err, commitlog.ErrOffsetOutOfRange):
+				return events, metadata, nil
+			case errors.As(err, &corrupted):
+				metadata.CorruptedOffsets = append(metadata.CorruptedOffsets, off)
+				metadata.NextOffset = off + 1
+				continue
+			default:
+				return nil, Metadata{}, err
+			}
+		}
+
+		var e Event
+		if err := json.Unmarshal(record, &e); err != nil {
+			return nil, Metadata{}, err
+		}
+		e.Offset = off
+		events = append(events, e)
+		metadata.NextOffset = off + 1
+	}
+	return events, metadata, nil
+}
+
+// DroppedBytes returns the number of bytes of the torn records truncated when
+// the trail was opened
+func (t *Trail) DroppedBytes() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.log.DroppedBytes()
+}
+
+// Close syncs and closes the commit log
+func (t *Trail) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.log.Close()
+}
@@ -12,25 +12,40 @@ type Filter struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// After is set when the client is walking the records with a cursor,
+	// in that case Page is ignored and rows after the cursor are returned
+	After *Cursor
 }
 
 type Metadata struct {
-	CurrentPage int `json:"current_page,omitempty`
-	PageSize    int `json:"page_size,omitempty`
-	FirstPage   int `json:"first_page,omitempty`
-	LastPage    int `json:"last_page,omitempty`
-	TotalRecord int `json:"total_record,omitempty`
+	CurrentPage int    `json:"current_page,omitempty"`
+	PageSize    int    `json:"page_size,omitempty"`
+	FirstPage   int    `json:"first_page,omitempty"`
+	LastPage    int    `json:"last_page,omitempty"`
+	TotalRecord int    `json:"total_record,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+	// Next is the position of the last row when there are more records
+	// the handler signs it and set the NextCursor
+	Next *Cursor `json:"-"`
 }
 
 func ValidateFilter(v *validator.Validator, f Filter) {
 	// check that page and pagesize has valid type and range of number
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 1_000_000, "page", "must be less than 1 million")
+	// page is not used with cursor so there is no need to check it
+	if f.After == nil {
+		v.Check(f.Page > 0, "page", "must be greater than zero")
+		v.Check(f.Page <= 1_000_000, "page", "must be less than 1 million")
+	}
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 20, "page_size", "must be less than twenty")
 
 	// now check if the sort pramter contains the values match in the SortSafelist
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort field")
+
+	// cursor only points to a position for the sort it was created with
+	if f.After != nil {
+		v.Check(f.After.Sort == f.Sort, "cursor", "does not match the sort field")
+	}
 }
 
 // return sortColumn value and trim prefix if its '-'
@@ -58,9 +73,30 @@ func (f Filter) limit() int {
 }
 
 func (f Filter) offset() int {
+	// rows are selected with the cursor position instead of skipping them
+	if f.After != nil {
+		return 0
+	}
 	return f.PageSize * (f.Page - 1)
 }
 
+// get the comparison operator for the keyset condition, rows after the cursor
+// are greater for ascending sort and smaller for descending sort
+func (f Filter) cursorOperator() string {
+	if f.sortDirection() == "DESC" {
+		return "<"
+	}
+	return ">"
+}
+
+// metadata for the cursor mode, total record and pages has no meaning when walking with cursor
+func calculateCursorMetaData(pageSize int, next *Cursor) Metadata {
+	return Metadata{
+		PageSize: pageSize,
+		Next:     next,
+	}
+}
+
 func calculateMetaData(TotalRecord, page, pageSize int) Metadata {
 	if TotalRecord == 0 {
 		return Metadata{}
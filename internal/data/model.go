@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // custom error for get method when record isn't found
@@ -19,14 +20,20 @@ type Models struct {
 	Users       UserModel
 	Token       TokenModel
 	Permissions PermissionsModel
+	Roles       RoleModel
 }
 
-func NewModel(db *sql.DB) Models {
+// permissionsCacheTTL is how long the permissions of a user are cached, zero disables the cache
+func NewModel(db *sql.DB, permissionsCacheTTL time.Duration) Models {
+	// permission and role models share the cache so role changes invalidate it as well
+	cache := newPermissionCache(permissionsCacheTTL)
+
 	return Models{
 		Movies:      MovieModel{DB: db},
 		Users:       UserModel{DB: db},
 		Token:       TokenModel{DB: db},
-		Permissions: PermissionsModel{DB: db},
+		Permissions: PermissionsModel{DB: db, cache: cache},
+		Roles:       RoleModel{DB: db, cache: cache},
 	}
 }
 
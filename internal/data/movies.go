@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
@@ -88,19 +91,21 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // add method for update the record in the movie table
-func (m MovieModel) Delete(id int64) error {
+// the movie is only deleted if it still has the version the caller read, so a
+// concurrent update is not deleted with it
+func (m MovieModel) Delete(id int64, version int32) error {
 	// check if the id is positive
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	// construct qeury to delete the movie with id
-	qeury := `DELETE FROM movies WHERE id = $1`
+	// construct qeury to delete the movie with id and version
+	qeury := `DELETE FROM movies WHERE id = $1 AND version = $2`
 
 	// since the we are not returning anything we can use exec() method
 	// which will return result.rowsaffected() which contains information about how many
 	// rows has been affected , if 0 rows affectd means that movies with id
-	// coulnd not be found and if found it will return 1 in  result.rowsaffected()
-	result, err := m.DB.Exec(qeury, id)
+	// was deleted or updated since it was read
+	result, err := m.DB.Exec(qeury, id, version)
 	if err != nil {
 		return err
 	}
@@ -109,7 +114,7 @@ func (m MovieModel) Delete(id int64) error {
 		return err
 	}
 	if rowsaffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 	// otherwise result sould be one since we are using primary key to delete record
 	// return nil upon movie delete
@@ -119,11 +124,12 @@ func (m MovieModel) Delete(id int64) error {
 // add method to update the record in the movie table
 func (m MovieModel) Update(movie *Movie) error {
 	// write update movie query  for title, runtime, genres, year
-	// also update the version with each update
+	// also update the version with each update, the version in where clause
+	// makes sure that the movie wasn't changed since it was read
 	query := `
        UPDATE movies
        SET title = $1, year = $2, runtime = $3, genres = $4, version= version + 1
-       WHERE id= $5
+       WHERE id= $5 AND version = $6
        RETURNING version`
 
 	args := []interface{}{
@@ -132,6 +138,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Runtime,
 		pq.Array(movie.Genres),
 		movie.ID,
+		movie.Version,
 	}
 	err := m.DB.QueryRow(query, args...).Scan(&movie.Version)
 	if err != nil {
@@ -145,6 +152,115 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
+// postgres type of the sortable columns, the cursor value is stored as string
+// and is cast back to column type in the keyset condition
+var movieSortColumnTypes = map[string]string{
+	"id":      "bigint",
+	"title":   "text",
+	"year":    "integer",
+	"runtime": "integer",
+}
+
+// GetAll returns a page of movies matching the title and genres filters along with
+// the pagination metadata. title is matched with full-text search so it can use the
+// movie_title_idx GIN index, and genres uses the @> operator backed by movie_genres_idx.
+// when filters.After is set the rows after the cursor position are returned instead of
+// using the offset, which stays fast and stable while rows are inserted.
+func (m MovieModel) GetAll(title string, genres []string, filters Filter) ([]*Movie, Metadata, error) {
+	args := []interface{}{title, pq.Array(genres), filters.limit() + 1, filters.offset()}
+
+	// keyset condition compares (sort column, id) with the cursor position
+	keyset := "TRUE"
+	if filters.After != nil {
+		keyset = fmt.Sprintf("(%s, id) %s ($5::%s, $6)", filters.sortColumn(), filters.cursorOperator(), movieSortColumnTypes[filters.sortColumn()])
+		args = append(args, filters.After.Value, filters.After.ID)
+	}
+
+	// the sort column and direction are checked against the safelist in sortColumn()
+	// so it is safe to interpolate them, id is always added as secondary sort key
+	// to keep the order consistent between pages. one extra row is fetched to know
+	// if there is a next page
+	query := fmt.Sprintf(`
+	    SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	    FROM movies
+	    WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	    AND (genres @> $2 OR $2 = '{}')
+	    AND %s
+	    ORDER BY %s %s, id %s
+	    LIMIT $3 OFFSET $4`, keyset, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// drop the extra row and point the next cursor at the last returned movie
+	var next *Cursor
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		next = movieCursor(filters, movies[len(movies)-1])
+	}
+
+	if filters.After != nil {
+		return movies, calculateCursorMetaData(filters.PageSize, next), nil
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	metadata.Next = next
+	return movies, metadata, nil
+}
+
+// create the cursor with the value of sort column of the movie
+func movieCursor(filters Filter, movie *Movie) *Cursor {
+	var value string
+	switch filters.sortColumn() {
+	case "title":
+		value = movie.Title
+	case "year":
+		value = strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		value = strconv.FormatInt(int64(movie.Runtime), 10)
+	default:
+		value = strconv.FormatInt(movie.ID, 10)
+	}
+
+	return &Cursor{
+		Sort:  filters.Sort,
+		Value: value,
+		ID:    movie.ID,
+	}
+}
+
 // mock movie struct for unit testing
 type MockMovieModel struct {
 	DB *sql.DB
@@ -158,10 +274,14 @@ func (m MockMovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MockMovieModel) Delete(id int64) error {
+func (m MockMovieModel) Delete(id int64, version int32) error {
 	return nil
 }
 
 func (m MockMovieModel) Get(id int64) (*Movie, error) {
 	return nil, nil
 }
+
+func (m MockMovieModel) GetAll(title string, genres []string, filters Filter) ([]*Movie, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // define permission slice
@@ -21,15 +23,28 @@ func (p Permissions) Include(code string) bool {
 
 // permission model for database
 type PermissionsModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	cache *permissionCache
 }
 
 func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+	// use the cached permissions if they are not expired
+	if permissions, found := m.cache.get(userID); found {
+		return permissions, nil
+	}
+	gen := m.cache.generation()
+
+	// permissions granted directly to the user and the permissions of user's roles
 	query := `SELECT  permissions.code 
             FROM permissions
             INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-            INNER JOIN users ON users_permissions.user_id = users.id
-            WHERE users.id = $1`
+            WHERE users_permissions.user_id = $1
+            UNION
+            SELECT permissions.code
+            FROM permissions
+            INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+            INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+            WHERE users_roles.user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
@@ -56,6 +71,82 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+
+	m.cache.set(userID, permissions, gen)
 	return permissions, nil
 
 }
+
+// GetAll returns the codes of all the permissions
+func (m PermissionsModel) GetAll() (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AddForUser grants the permissions to the user directly, permissions which the
+// user already has are ignored
+func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+    INSERT INTO users_permissions
+    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	// cached permissions of the user are no longer valid
+	m.cache.invalidate(userID)
+	return nil
+}
+
+// RemoveForUser revokes the permissions granted directly to the user
+func (m PermissionsModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+    DELETE FROM users_permissions
+    USING permissions
+    WHERE users_permissions.permission_id = permissions.id
+    AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	// cached permissions of the user are no longer valid
+	m.cache.invalidate(userID)
+	return nil
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// permissionCache keeps the permissions of users in memory for ttl so that
+// protected requests don't query the database every time. the entries are
+// invalidated whenever the grants of the user are changed through this process.
+// the invalidation doesn't reach the other replicas, they keep granting the
+// revoked permissions for up to ttl, so the ttl should stay short
+type permissionCache struct {
+	mu        sync.RWMutex
+	ttl       time.Duration
+	entries   map[int64]permissionCacheEntry
+	lastSweep time.Time
+	// incremented by every invalidate, permissions read from the database before
+	// the invalidate are not cached
+	gen uint64
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// newPermissionCache returns nil when ttl is not positive, which disables caching
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &permissionCache{
+		ttl:       ttl,
+		entries:   make(map[int64]permissionCacheEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (c *permissionCache) get(userID int64) (Permissions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, found := c.entries[userID]
+	if !found || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+// generation returns the value to pass to set, it is read before the permissions
+// are queried from the database
+func (c *permissionCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+// set caches the permissions unless the cache was invalidated since gen was read,
+// then the permissions may be stale
+func (c *permissionCache) set(userID int64, permissions Permissions, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	now := time.Now()
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      now.Add(c.ttl),
+	}
+
+	// remove the expired entries once every ttl so the map doesn't keep growing
+	if now.Sub(c.lastSweep) > c.ttl {
+		for id, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+}
+
+func (c *permissionCache) invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.gen++
+}
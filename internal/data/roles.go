@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// roles which are seeded in the database, each role bundle set of permissions
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// DefaultRole is assigned to every new user on registration
+const DefaultRole = RoleViewer
+
+// Role holds the name of the role and the permissions it grants
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// role model for database
+type RoleModel struct {
+	DB *sql.DB
+	// same cache as PermissionsModel, role changes also change the permissions
+	cache *permissionCache
+}
+
+// GetAll returns all the roles with their permissions
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+    SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.id) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+    FROM roles
+    LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+    LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+    GROUP BY roles.id
+    ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetAllForUser returns the names of the roles assigned to the user
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+    SELECT roles.name
+    FROM roles
+    INNER JOIN users_roles ON users_roles.role_id = roles.id
+    WHERE users_roles.user_id = $1
+    ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AddForUser assigns the roles to the user, roles the user already has are ignored
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+    INSERT INTO users_roles
+    SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+    ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate(userID)
+	return nil
+}
+
+// RemoveForUser removes the roles from the user
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+    DELETE FROM users_roles
+    USING roles
+    WHERE users_roles.role_id = roles.id
+    AND users_roles.user_id = $1 AND roles.name = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate(userID)
+	return nil
+}
@@ -2,18 +2,19 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
 	"time"
 
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
-	"golang.org/x/exp/rand"
 )
 
 const (
 	ScopeActivation = "activation"
   ScopeAuthentication = "authentication"
+	ScopePasswordReset = "password-reset"
 )
 
 // token for authentication
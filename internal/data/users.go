@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/DhruvinShiroya/greenlight/internal/validator"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -105,14 +106,21 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// add new user to the database
-func (m UserModel) Insert(user *User) error {
+// add new user to the database with the roles, both are inserted by one statement
+// so the user is never left without the roles
+func (m UserModel) Insert(user *User, roles ...string) error {
 	query := `
-    INSERT INTO users (name, email, activated, password_hash)
-    VALUES ($1 , $2, $3, $4)
-    RETURNING id, created_at, version`
-
-	args := []interface{}{user.Name, user.Email, user.Activated, user.Password.hash}
+    WITH new_user AS (
+        INSERT INTO users (name, email, activated, password_hash)
+        VALUES ($1 , $2, $3, $4)
+        RETURNING id, created_at, version
+    ), new_roles AS (
+        INSERT INTO users_roles
+        SELECT new_user.id, roles.id FROM new_user, roles WHERE roles.name = ANY($5)
+    )
+    SELECT id, created_at, version FROM new_user`
+
+	args := []interface{}{user.Name, user.Email, user.Activated, user.Password.hash, pq.Array(roles)}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
@@ -129,6 +137,40 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Retrive user by id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, email, password_hash, activated, version
+                  FROM users WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreateAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // Retrive user by Email
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `SELECT id, created_at, name, email, password_hash, activated, version
@@ -0,0 +1,211 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	commitlog "github.com/DhruvinShiroya/greenlight/internal/log"
+)
+
+// types of the movie change events
+const (
+	MovieCreated = "movie.created"
+	MovieUpdated = "movie.updated"
+	MovieDeleted = "movie.deleted"
+)
+
+// Event is the change published to the subscribers. when the broker has the commit
+// log the ID is the offset of the event in the log, otherwise it is the sequence
+// number since the broker was created
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Broker publishes the events to the subscribers in the process and optionally
+// appends them to the commit log, so the subscribers can resume from the offset
+// after reconnecting
+type Broker struct {
+	mu          sync.Mutex
+	log         *commitlog.Log
+	nextID      uint64
+	buffer      int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns the broker, log is nil when the events are not persisted.
+// buffer is the number of events held for each subscriber
+func NewBroker(log *commitlog.Log, buffer int) (*Broker, error) {
+	b := &Broker{
+		log:         log,
+		buffer:      buffer,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+
+	// continue the ids after the events already in the log, the highest offset
+	// can't be read only when the log is empty
+	if log != nil {
+		highest, err := log.HighestOffset()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := log.Read(highest); !errors.Is(err, commitlog.ErrOffsetOutOfRange) {
+			b.nextID = highest + 1
+		}
+	}
+	return b, nil
+}
+
+// Persistent reports whether the events are written to the commit log and can be
+// replayed
+func (b *Broker) Persistent() bool {
+	return b.log != nil
+}
+
+// Publish appends the event to the log and sends it to all the subscribers. the
+// subscriber which is not keeping up is closed, it can resume with Replay
+func (b *Broker) Publish(eventType string, data any) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	// the lock is held while appending so the events are sent in the order of their ids
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{
+		ID:   b.nextID,
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Data: payload,
+	}
+
+	if b.log != nil {
+		record, err := json.Marshal(event)
+		if err != nil {
+			return Event{}, err
+		}
+		event.ID, err = b.log.Append(record)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	b.nextID = event.ID + 1
+
+	for s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			b.unsubscribe(s)
+		}
+	}
+	return event, nil
+}
+
+// Subscription receives the published events until it is closed
+type Subscription struct {
+	broker *Broker
+	events chan Event
+}
+
+// Events returns the channel of the events, it is closed when the subscription
+// is closed by Close, by the broker or because the subscriber fell behind
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+// Subscribe returns the subscription to the events published after this call
+func (b *Broker) Subscribe() *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{
+		broker: b,
+		events: make(chan Event, b.buffer),
+	}
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes the subscriber and closes its channel, the caller must
+// hold the mutex
+func (b *Broker) unsubscribe(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Replay calls fn for the events in the log with id greater than after, up to
+// the last event published before the call. ids removed from the log and the
+// corrupted records are skipped
+func (b *Broker) Replay(after uint64, fn func(Event) error) error {
+	if b.log == nil {
+		return nil
+	}
+
+	lowest, err := b.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	next := b.nextID
+	b.mu.Unlock()
+
+	start := after + 1
+	if start < lowest {
+		start = lowest
+	}
+
+	for off := start; off < next; off++ {
+		record, err := b.log.Read(off)
+		if err != nil {
+			var corrupted *commitlog.CorruptionError
+			if errors.As(err, &corrupted) {
+				continue
+			}
+			return err
+		}
+
+		var event Event
+		if err := json.Unmarshal(record, &event); err != nil {
+			return err
+		}
+		event.ID = off
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all the subscriptions and the following ones, the events are still
+// appended to the log. the log is not closed by the broker
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		b.unsubscribe(s)
+	}
+}
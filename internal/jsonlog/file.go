@@ -0,0 +1,276 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// time layout of the rotated file suffix, it sorts in the order of rotation
+const backupTimeFormat = "20060102T150405.000000000"
+
+// FileOptions controls when the log file is rotated and how many backups are kept
+type FileOptions struct {
+	// rotate when the file would grow over MaxSize bytes, 0 disables it
+	MaxSize int64
+	// rotate when the first entry of the new day (UTC) is written
+	Daily bool
+	// number of compressed backups to keep, 0 keeps all of them
+	MaxBackups int
+}
+
+// File is the log output which writes to the file and rotates it. the rotated file
+// is renamed to <path>.<time>, compressed with gzip in the background and the oldest
+// backups over MaxBackups are removed
+type File struct {
+	path string
+	opts FileOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	day  string
+
+	// compression of the rotated files runs one at a time in the background
+	compressMu sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// OpenFile opens the log file for appending, the file is created if missing
+func OpenFile(path string, opts FileOptions) (*File, error) {
+	f := &File{
+		path: path,
+		opts: opts,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at the path, the caller must hold the mutex
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	// existing file belongs to the day it was last written
+	f.day = info.ModTime().UTC().Format(time.DateOnly)
+	if f.size == 0 {
+		f.day = time.Now().UTC().Format(time.DateOnly)
+	}
+	return nil
+}
+
+// Write writes the entry to the file, rotating it first if the entry would
+// exceed MaxSize or the day has changed
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.shouldRotate(int64(len(p))) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) shouldRotate(n int64) bool {
+	// empty file is never rotated, even if single entry is over the limit
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.Daily && time.Now().UTC().Format(time.DateOnly) != f.day
+}
+
+// Rotate rotates the file now
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// rotate renames the current file and opens the new one, the caller must hold the mutex
+func (f *File) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	f.file = nil
+
+	backup := f.backupName(time.Now().UTC())
+	err = os.Rename(f.path, backup)
+	if err != nil {
+		// keep writing to the current file
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.compressMu.Lock()
+		defer f.compressMu.Unlock()
+
+		// the logger writes to this file so the errors can only go to stderr
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "jsonlog: compress %s: %v\n", backup, err)
+		}
+		if err := f.removeOldBackups(); err != nil {
+			fmt.Fprintf(os.Stderr, "jsonlog: remove old backups: %v\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// backupName returns the name for the rotated file which is not used by the
+// previous backups, the time is moved forward if the name is taken
+func (f *File) backupName(t time.Time) string {
+	for {
+		name := f.path + "." + t.Format(backupTimeFormat)
+		_, err := os.Stat(name)
+		_, gzErr := os.Stat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+// Reopen closes and opens the file at the path again. it is used after an external
+// tool like logrotate has moved the file, usually on SIGUSR1
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	f.file = nil
+
+	return f.open()
+}
+
+// Close closes the file and waits for the compression of the rotated files
+func (f *File) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+}
+
+// compressFile writes the gzip copy of the file to <name>.gz and removes the file
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// removeOldBackups keeps the newest MaxBackups compressed backups
+func (f *File) removeOldBackups() error {
+	if f.opts.MaxBackups <= 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(f.path + ".*.gz")
+	if err != nil {
+		return err
+	}
+
+	// only the files with our time suffix are backups
+	prefix := f.path + "."
+	var backups []string
+	for _, name := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	// the time suffix sorts the backups from oldest to newest
+	sort.Strings(backups)
+
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
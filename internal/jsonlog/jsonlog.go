@@ -2,10 +2,14 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,80 +18,197 @@ type Level int8
 
 // create const which represent severity level
 const (
-	LevelInfo  Level = iota // has val 0
-	LevelError              // has val 1
-	LevelFatal              // has val 2
-	LevelOff                // has val 3
+	LevelDebug Level = iota // has val 0
+	LevelInfo               // has val 1
+	LevelWarn               // has val 2
+	LevelError              // has val 3
+	LevelFatal              // has val 4
+	LevelOff                // has val 5
 )
 
 // get the string representation of the level
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 
 }
 
+// ParseLevel returns the level for its name, the name is case insensitive
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelOff, fmt.Errorf("invalid log level %q", s)
+}
+
 // define custom Logger type to hold the output destination that the log
 // will write to, minimum severity level that log entries will be written for,
-// mutex for writing cordination
+// mutex for writing cordination. minLevel is atomic so it can be changed while
+// other goroutines are logging
 type Logger struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
+
+	// keys and hook for masking the sensitive properties
+	redactMu      sync.RWMutex
+	sensitiveKeys map[string]bool
+	redactFunc    RedactFunc
+
+	// layout of the time field, when to attach stack trace and whether to
+	// include the caller, atomic so they can be changed while logging
+	timeFormat  atomic.Value
+	tracePolicy atomic.Int32
+	caller      atomic.Bool
+
+	// nil when the entries are not sampled
+	sampler atomic.Pointer[sampler]
 }
 
+// NewLogger returns the logger which masks the DefaultSensitiveKeys, writes the time
+// as RFC3339Nano, includes the caller and attaches stack trace to unexpected errors
 func NewLogger(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+	l := &Logger{
+		out: out,
 	}
+	l.minLevel.Store(int32(minLevel))
+	l.SetSensitiveKeys(DefaultSensitiveKeys...)
+	l.SetTimeFormat(time.RFC3339Nano)
+	l.SetTracePolicy(TraceUnexpected)
+	l.SetCaller(true)
+	return l
+}
+
+// SetLevel changes the minimum severity level of the log entries at runtime
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
+}
+
+// Level returns the current minimum severity level
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
+// SetTimeFormat sets the time layout used for the time field of the entries
+func (l *Logger) SetTimeFormat(layout string) {
+	l.timeFormat.Store(layout)
+}
+
+// SetTracePolicy sets which entries get the stack trace
+func (l *Logger) SetTracePolicy(policy TracePolicy) {
+	l.tracePolicy.Store(int32(policy))
+}
+
+// SetCaller sets whether the file:line of the caller is included in the entries
+func (l *Logger) SetCaller(enabled bool) {
+	l.caller.Store(enabled)
+}
+
+func (l *Logger) PrintDebug(message string, properties map[string]any) {
+	l.print(0, LevelDebug, message, properties, false)
 }
 
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+func (l *Logger) PrintInfo(message string, properties map[string]any) {
+	l.print(0, LevelInfo, message, properties, false)
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+func (l *Logger) PrintWarn(message string, properties map[string]any) {
+	l.print(0, LevelWarn, message, properties, false)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+// PrintError writes the error, the stack trace is attached only if the policy
+// allows it and the error is wrapped with Unexpected
+func (l *Logger) PrintError(err error, properties map[string]any) {
+	l.print(0, LevelError, err.Error(), properties, IsUnexpected(err))
+}
+
+// PrintErrorDepth is PrintError for the helpers which log the errors of their
+// callers, depth is the number of the frames to skip above the caller of
+// PrintErrorDepth so the caller field points to the code which had the error
+func (l *Logger) PrintErrorDepth(depth int, err error, properties map[string]any) {
+	l.print(depth, LevelError, err.Error(), properties, IsUnexpected(err))
+}
+
+func (l *Logger) PrintFatal(err error, properties map[string]any) {
+	l.print(0, LevelFatal, err.Error(), properties, IsUnexpected(err))
 	os.Exit(1) // for FATAL entry level
 }
 
-// print is method to write the log internally
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+// print is called by the Print methods, it finds the caller of the Print method,
+// skipping depth more frames, and writes the entry
+func (l *Logger) print(depth int, level Level, message string, properties map[string]any, unexpected bool) (int, error) {
+	// if the severity level is of the log entry is below minimum level for log
+	// then return with no action, checked before looking up the caller
+	if level < l.Level() {
+		return 0, nil
+	}
+
+	// skip runtime.Callers, print and the Print method
+	var pc uintptr
+	if l.caller.Load() {
+		var pcs [1]uintptr
+		runtime.Callers(3+depth, pcs[:])
+		pc = pcs[0]
+	}
+
+	return l.output(level, message, properties, pc, unexpected)
+}
+
+// output is method to write the log internally, pc is the program counter of the
+// caller or 0 if unknown
+func (l *Logger) output(level Level, message string, properties map[string]any, pc uintptr, unexpected bool) (int, error) {
 
 	// if the severity level is of the log entry is below minimum level for log
 	// then return with no action
-	if level < l.minLevel {
+	if level < l.Level() {
 		return 0, nil
 	}
 
+	// drop the entry if the sampler has seen too many of the same message
+	if s := l.sampler.Load(); s != nil && !s.allow(level, message) {
+		return 0, nil
+	}
+
+	return l.write(level, message, properties, pc, unexpected)
+}
+
+// write formats the entry and writes it to the output
+func (l *Logger) write(level Level, message string, properties map[string]any, pc uintptr, unexpected bool) (int, error) {
 	// create a struct to hold log entry
 	record := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Caller     string         `json:"caller,omitempty"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC1123),
+		Time:       time.Now().UTC().Format(l.timeFormat.Load().(string)),
 		Message:    message,
-		Properties: properties,
+		Properties: l.prepareProperties(properties),
+	}
+	if pc != 0 && l.caller.Load() {
+		record.Caller = caller(pc)
 	}
-	// include a stack trace for entries at the Error or Fatal levels
-	if level >= LevelError {
+	// include a stack trace when the policy asks for it
+	if TracePolicy(l.tracePolicy.Load()).includeTrace(level, unexpected) {
 		record.Trace = string(debug.Stack())
 	}
 	// declare a line variable for log entry test
@@ -110,7 +231,7 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 }
 
 // implement Write() method on our Logger type so thatit satisfy  the io.Writer
-// interface,
+// interface, the caller is not known here
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.output(LevelError, string(message), nil, 0, false)
 }
@@ -0,0 +1,116 @@
+package jsonlog
+
+import (
+	"encoding"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the value written instead of the value of a sensitive property
+const redacted = "[REDACTED]"
+
+// DefaultSensitiveKeys are the property keys which are masked by default
+var DefaultSensitiveKeys = []string{"email", "token", "password", "authorization"}
+
+// RedactFunc is called for every property after the sensitive keys are masked,
+// the returned value is written instead of the original value
+type RedactFunc func(key string, value any) any
+
+// SetSensitiveKeys replaces the keys whose values are masked. keys are matched
+// case insensitive and also as suffix, so "email" masks "user_email" as well
+func (l *Logger) SetSensitiveKeys(keys ...string) {
+	sensitive := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		sensitive[strings.ToLower(key)] = true
+	}
+
+	l.redactMu.Lock()
+	defer l.redactMu.Unlock()
+	l.sensitiveKeys = sensitive
+}
+
+// SetRedactFunc sets the hook for redacting properties which can't be matched by key
+func (l *Logger) SetRedactFunc(fn RedactFunc) {
+	l.redactMu.Lock()
+	defer l.redactMu.Unlock()
+	l.redactFunc = fn
+}
+
+func (l *Logger) isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if l.sensitiveKeys[key] {
+		return true
+	}
+	if i := strings.LastIndexAny(key, "_-."); i >= 0 {
+		return l.sensitiveKeys[key[i+1:]]
+	}
+	return false
+}
+
+// prepareProperties masks the sensitive values and converts the values which don't
+// have a useful JSON form, like durations and errors, nested maps are handled as well
+func (l *Logger) prepareProperties(properties map[string]any) map[string]any {
+	if len(properties) == 0 {
+		return nil
+	}
+
+	l.redactMu.RLock()
+	defer l.redactMu.RUnlock()
+
+	return l.prepareMap(properties)
+}
+
+func (l *Logger) prepareMap(properties map[string]any) map[string]any {
+	out := make(map[string]any, len(properties))
+	for key, value := range properties {
+		if l.isSensitive(key) {
+			out[key] = redacted
+			continue
+		}
+
+		value = l.prepareValue(value)
+		if l.redactFunc != nil {
+			value = l.redactFunc(key, value)
+		}
+		out[key] = value
+	}
+	return out
+}
+
+func (l *Logger) prepareValue(value any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Duration:
+		// "1.5s" is easier to read than nanoseconds
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case map[string]any:
+		return l.prepareMap(v)
+	case map[string]string:
+		nested := make(map[string]any, len(v))
+		for key, value := range v {
+			nested[key] = value
+		}
+		return l.prepareMap(nested)
+	case []any:
+		values := make([]any, len(v))
+		for i := range v {
+			values[i] = l.prepareValue(v[i])
+		}
+		return values
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, []string:
+		return v
+	case encoding.TextMarshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		// structs and other values are marshalled as they are
+		return v
+	}
+}
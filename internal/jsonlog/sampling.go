@@ -0,0 +1,179 @@
+package jsonlog
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampling limits how many entries with the same level and message are written in
+// each interval. the First entries are written, after that only every Thereafter-th
+// entry is written and the rest are counted. when the interval ends the suppressed
+// counts are reported and the counting starts again. only the entries of Levels are
+// sampled, DefaultSampledLevels when it is empty, so the info entries like the access
+// log are always written
+type Sampling struct {
+	Interval   time.Duration
+	First      int
+	Thereafter int
+	Levels     []Level
+}
+
+// DefaultSampledLevels are the levels sampled when Sampling.Levels is empty
+var DefaultSampledLevels = []Level{LevelWarn, LevelError}
+
+const (
+	// maxSampleKeys caps the messages counted in one interval, the entries with
+	// other messages are counted together under otherMessages
+	maxSampleKeys = 1000
+	otherMessages = "(other messages)"
+
+	// maxSampleMessage is the length of the message prefix the entries are keyed by
+	maxSampleMessage = 256
+)
+
+// sampleKey identifies the entries which are counted together
+type sampleKey struct {
+	level   Level
+	message string
+}
+
+type sampleCount struct {
+	seen       int
+	suppressed int
+}
+
+type sampler struct {
+	cfg    Sampling
+	logger *Logger
+	levels [LevelOff + 1]bool
+
+	mu     sync.Mutex
+	counts map[sampleKey]*sampleCount
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newSampler(l *Logger, cfg Sampling) *sampler {
+	s := &sampler{
+		cfg:     cfg,
+		logger:  l,
+		counts:  make(map[sampleKey]*sampleCount),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	levels := cfg.Levels
+	if len(levels) == 0 {
+		levels = DefaultSampledLevels
+	}
+	for _, level := range levels {
+		if level >= LevelDebug && level <= LevelOff {
+			s.levels[level] = true
+		}
+	}
+	go s.run()
+	return s
+}
+
+// allow reports whether the entry should be written
+func (s *sampler) allow(level Level, message string) bool {
+	if level < LevelDebug || level > LevelOff || !s.levels[level] {
+		return true
+	}
+
+	if len(message) > maxSampleMessage {
+		message = message[:maxSampleMessage]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sampleKey{level: level, message: message}
+	c, ok := s.counts[key]
+	if !ok {
+		// too many different messages, count this one with the others
+		if len(s.counts) >= maxSampleKeys {
+			key.message = otherMessages
+			c, ok = s.counts[key]
+		}
+		if !ok {
+			c = &sampleCount{}
+			s.counts[key] = c
+		}
+	}
+	c.seen++
+
+	if c.seen <= s.cfg.First {
+		return true
+	}
+	if s.cfg.Thereafter > 0 && (c.seen-s.cfg.First)%s.cfg.Thereafter == 0 {
+		return true
+	}
+	c.suppressed++
+	return false
+}
+
+// run resets the counts at the end of every interval until the sampler is stopped
+func (s *sampler) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush starts the new interval and writes one entry for each message which had
+// suppressed entries, the report is written at the level of suppressed entries
+// and is not sampled itself
+func (s *sampler) flush() {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[sampleKey]*sampleCount)
+	s.mu.Unlock()
+
+	for key, c := range counts {
+		if c.suppressed == 0 {
+			continue
+		}
+		s.logger.write(key.level, "log messages suppressed", map[string]any{
+			"message":    key.message,
+			"suppressed": c.suppressed,
+			"interval":   s.cfg.Interval,
+		}, 0, false)
+	}
+}
+
+// stop reports the counts of the current interval and waits for the sampler to exit
+func (s *sampler) stop() {
+	close(s.done)
+	<-s.stopped
+}
+
+// SetSampling enables the sampling of the entries, the zero Sampling or one
+// without Interval disables it. the counts of the previous sampling are reported
+func (l *Logger) SetSampling(cfg Sampling) {
+	var s *sampler
+	if cfg.Interval > 0 {
+		s = newSampler(l, cfg)
+	}
+
+	if old := l.sampler.Swap(s); old != nil {
+		old.stop()
+	}
+}
+
+// Close stops the sampling and reports the suppressed counts, the logger can still
+// be used after Close but the entries are not sampled
+func (l *Logger) Close() error {
+	l.SetSampling(Sampling{})
+	return nil
+}
@@ -0,0 +1,146 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogHandler is a slog.Handler which writes the records with the Logger, so the
+// code using log/slog writes the same JSON lines as the rest of the application.
+// the attributes become the properties of the entry and groups are nested objects
+type SlogHandler struct {
+	logger *Logger
+	// attributes added with WithAttrs, already nested in their groups
+	properties map[string]any
+	// groups opened with WithGroup, the attributes of the record are added to the last one
+	groups []string
+}
+
+// NewSlogHandler returns the handler which writes to the logger
+func NewSlogHandler(logger *Logger) *SlogHandler {
+	return &SlogHandler{logger: logger}
+}
+
+// slogLevel maps the slog level to the closest level of the logger, levels between
+// the standard slog levels are rounded down
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return slogLevel(level) >= h.logger.Level()
+}
+
+func (h *SlogHandler) Handle(_ context.Context, record slog.Record) error {
+	properties := copyProperties(h.properties)
+
+	// error attribute wrapped with Unexpected asks for the stack trace
+	unexpected := false
+	if record.NumAttrs() > 0 {
+		group := openGroups(properties, h.groups)
+		record.Attrs(func(attr slog.Attr) bool {
+			if err, ok := attr.Value.Any().(error); ok && IsUnexpected(err) {
+				unexpected = true
+			}
+			addAttr(group, attr)
+			return true
+		})
+	}
+
+	_, err := h.logger.output(slogLevel(record.Level), record.Message, properties, record.PC, unexpected)
+	return err
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	properties := copyProperties(h.properties)
+	group := openGroups(properties, h.groups)
+	for _, attr := range attrs {
+		addAttr(group, attr)
+	}
+
+	return &SlogHandler{logger: h.logger, properties: properties, groups: h.groups}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	// handler must return itself for the empty group name
+	if name == "" {
+		return h
+	}
+
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+
+	return &SlogHandler{logger: h.logger, properties: h.properties, groups: append(groups, name)}
+}
+
+// addAttr adds the attribute to the properties, group values become nested maps
+// and the group with empty key is inlined
+func addAttr(properties map[string]any, attr slog.Attr) {
+	value := attr.Value.Resolve()
+
+	// empty attributes are ignored
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if value.Kind() == slog.KindGroup {
+		attrs := value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+
+		group := properties
+		if attr.Key != "" {
+			nested, ok := properties[attr.Key].(map[string]any)
+			if !ok {
+				nested = make(map[string]any)
+				properties[attr.Key] = nested
+			}
+			group = nested
+		}
+		for _, a := range attrs {
+			addAttr(group, a)
+		}
+		return
+	}
+
+	properties[attr.Key] = value.Any()
+}
+
+// openGroups returns the map for the innermost group, the maps are created if missing
+func openGroups(properties map[string]any, groups []string) map[string]any {
+	for _, name := range groups {
+		nested, ok := properties[name].(map[string]any)
+		if !ok {
+			nested = make(map[string]any)
+			properties[name] = nested
+		}
+		properties = nested
+	}
+	return properties
+}
+
+// copyProperties makes a deep copy of the nested maps so handlers don't share them
+func copyProperties(properties map[string]any) map[string]any {
+	out := make(map[string]any, len(properties))
+	for key, value := range properties {
+		if nested, ok := value.(map[string]any); ok {
+			value = copyProperties(nested)
+		}
+		out[key] = value
+	}
+	return out
+}
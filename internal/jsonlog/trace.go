@@ -0,0 +1,110 @@
+package jsonlog
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// TracePolicy decides which entries get the stack trace
+type TracePolicy int8
+
+const (
+	TraceNever      TracePolicy = iota // no stack traces
+	TraceFatal                         // only for FATAL entries
+	TraceUnexpected                    // for FATAL entries and errors wrapped with Unexpected
+)
+
+func (p TracePolicy) String() string {
+	switch p {
+	case TraceNever:
+		return "never"
+	case TraceFatal:
+		return "fatal"
+	case TraceUnexpected:
+		return "unexpected"
+	default:
+		return ""
+	}
+}
+
+// ParseTracePolicy returns the policy for its name, the name is case insensitive
+func ParseTracePolicy(s string) (TracePolicy, error) {
+	for p := TraceNever; p <= TraceUnexpected; p++ {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return TraceNever, fmt.Errorf("invalid trace policy %q", s)
+}
+
+func (p TracePolicy) includeTrace(level Level, unexpected bool) bool {
+	switch p {
+	case TraceFatal:
+		return level == LevelFatal
+	case TraceUnexpected:
+		return level == LevelFatal || (level >= LevelError && unexpected)
+	default:
+		return false
+	}
+}
+
+// unexpectedError marks the error which is a bug rather than an expected failure
+type unexpectedError struct {
+	err error
+}
+
+func (e unexpectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e unexpectedError) Unwrap() error {
+	return e.err
+}
+
+// Unexpected wraps the error so the entry written for it gets the stack trace
+// under the TraceUnexpected policy
+func Unexpected(err error) error {
+	if err == nil || IsUnexpected(err) {
+		return err
+	}
+	return unexpectedError{err: err}
+}
+
+// IsUnexpected reports whether any error in the chain is wrapped with Unexpected
+func IsUnexpected(err error) bool {
+	var u unexpectedError
+	return errors.As(err, &u)
+}
+
+// named time layouts accepted by ParseTimeFormat
+var timeFormats = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"datetime":    time.DateTime,
+	"kitchen":     time.Kitchen,
+}
+
+// ParseTimeFormat returns the layout for the named format, any other value is
+// used as the layout itself
+func ParseTimeFormat(s string) string {
+	if layout, ok := timeFormats[strings.ToLower(s)]; ok {
+		return layout
+	}
+	return s
+}
+
+// caller returns the file:line for the program counter, the file is shortened to
+// the package directory and file name
+func caller(pc uintptr) string {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if frame.File == "" {
+		return ""
+	}
+	dir, file := filepath.Split(frame.File)
+	return fmt.Sprintf("%s:%d", filepath.Join(filepath.Base(dir), file), frame.Line)
+}
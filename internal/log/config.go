@@ -0,0 +1,68 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncPolicy decides when the appended records are synced to the disk
+type SyncPolicy int8
+
+const (
+	// records are written to the OS on each append and the OS decides when they
+	// reach the disk, they survive the crash of the process but not of the host
+	SyncOS SyncPolicy = iota
+	// every append is synced before it returns
+	SyncAlways
+	// appends are synced together after SyncInterval or once SyncBytes are unsynced
+	SyncGroup
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncOS:
+		return "os"
+	case SyncAlways:
+		return "always"
+	case SyncGroup:
+		return "group"
+	default:
+		return ""
+	}
+}
+
+// ParseSyncPolicy returns the policy for its name, the name is case insensitive
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for p := SyncOS; p <= SyncGroup; p++ {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return SyncOS, fmt.Errorf("invalid sync policy %q", s)
+}
+
+// Config sets the size limits of the segments, the new segment is created when
+// the store or the index of the active segment is full. Store sets how the
+// records are made durable
+type Config struct {
+	Segment struct {
+		MaxStoreBytes uint64
+		MaxIndexBytes uint64
+		// offset of the first record of the new log
+		InitialOffset uint64
+	}
+	Store struct {
+		Sync         SyncPolicy
+		SyncInterval time.Duration
+		SyncBytes    uint64
+		// with SyncGroup the append waits for the group sync which covers it
+		WaitForSync bool
+	}
+}
+
+const (
+	defaultMaxStoreBytes = 16 << 20
+	defaultMaxIndexBytes = 1 << 20
+	defaultSyncInterval  = 100 * time.Millisecond
+)
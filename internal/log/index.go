@@ -0,0 +1,112 @@
+package log
+
+import (
+	"io"
+	"os"
+)
+
+// each index entry is the record offset relative to the segment base offset
+// followed by the position of the record in the store
+var (
+	offWidth uint64 = 4
+	posWidth uint64 = 8
+	entWidth        = offWidth + posWidth
+)
+
+// index maps the record offsets to their position in the store. the file is
+// grown to MaxIndexBytes and memory mapped while open, on Close it is truncated
+// back to the size of the entries. where mmap is not available the file is read
+// into memory and every entry is also written to the file (see mmap_other.go)
+type index struct {
+	file *os.File
+	mmap []byte
+	size uint64
+}
+
+func newIndex(f *os.File, c Config) (*index, error) {
+	idx := &index{
+		file: f,
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	// entries past the last full one are left from the torn write
+	idx.size = uint64(fi.Size()) / entWidth * entWidth
+	if idx.size > c.Segment.MaxIndexBytes {
+		idx.size = c.Segment.MaxIndexBytes / entWidth * entWidth
+	}
+	if err = os.Truncate(f.Name(), int64(c.Segment.MaxIndexBytes)); err != nil {
+		return nil, err
+	}
+	if idx.mmap, err = mmap(f, int(c.Segment.MaxIndexBytes)); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Read returns the relative offset and the store position of the entry in, -1
+// reads the last entry. io.EOF is returned when the entry doesn't exist
+func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
+	if i.size == 0 {
+		return 0, 0, io.EOF
+	}
+	if in == -1 {
+		out = uint32((i.size / entWidth) - 1)
+	} else {
+		out = uint32(in)
+	}
+	pos = uint64(out) * entWidth
+	if i.size < pos+entWidth {
+		return 0, 0, io.EOF
+	}
+	out = enc.Uint32(i.mmap[pos : pos+offWidth])
+	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth])
+	return out, pos, nil
+}
+
+// Write appends the entry, io.EOF is returned when the index is full
+func (i *index) Write(off uint32, pos uint64) error {
+	if uint64(len(i.mmap)) < i.size+entWidth {
+		return io.EOF
+	}
+	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
+	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
+	if err := writeEntry(i.file, i.mmap, i.size); err != nil {
+		return err
+	}
+	i.size += entWidth
+	return nil
+}
+
+// entries returns the number of entries in the index
+func (i *index) entries() uint64 {
+	return i.size / entWidth
+}
+
+// truncate drops the entries after the first n
+func (i *index) truncate(n uint64) {
+	if n < i.entries() {
+		i.size = n * entWidth
+	}
+}
+
+func (i *index) Close() error {
+	if err := msync(i.mmap); err != nil {
+		return err
+	}
+	if err := i.file.Sync(); err != nil {
+		return err
+	}
+	if err := munmap(i.mmap); err != nil {
+		return err
+	}
+	if err := i.file.Truncate(int64(i.size)); err != nil {
+		return err
+	}
+	return i.file.Close()
+}
+
+func (i *index) Name() string {
+	return i.file.Name()
+}
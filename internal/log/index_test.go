@@ -0,0 +1,112 @@
+package log
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openIndex(t *testing.T, path string, maxBytes uint64) *index {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{}
+	c.Segment.MaxIndexBytes = maxBytes
+	idx, err := newIndex(f, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestIndex(t *testing.T) {
+	entries := []struct {
+		off uint32
+		pos uint64
+	}{
+		{off: 0, pos: 0},
+		{off: 1, pos: 10},
+		{off: 2, pos: 25},
+	}
+
+	tests := []struct {
+		name     string
+		maxBytes uint64
+		written  int
+		full     bool
+	}{
+		{name: "room for more", maxBytes: 1024, written: 3, full: false},
+		{name: "exactly full", maxBytes: 3 * entWidth, written: 3, full: true},
+		{name: "partial entry space is unused", maxBytes: 3*entWidth - 1, written: 2, full: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "0.index")
+			idx := openIndex(t, path, tt.maxBytes)
+
+			if _, _, err := idx.Read(-1); err != io.EOF {
+				t.Fatalf("read of empty index: got %v; want io.EOF", err)
+			}
+
+			written := 0
+			for _, e := range entries {
+				err := idx.Write(e.off, e.pos)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				written++
+			}
+			if written != tt.written {
+				t.Fatalf("written entries: got %d; want %d", written, tt.written)
+			}
+
+			for i := 0; i < written; i++ {
+				off, pos, err := idx.Read(int64(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if off != entries[i].off || pos != entries[i].pos {
+					t.Fatalf("entry %d: got (%d, %d); want (%d, %d)", i, off, pos, entries[i].off, entries[i].pos)
+				}
+			}
+			if _, _, err := idx.Read(int64(written)); err != io.EOF {
+				t.Fatalf("read past the last entry: got %v; want io.EOF", err)
+			}
+			if full := idx.Write(99, 99) == io.EOF; full != tt.full {
+				t.Fatalf("full: got %t; want %t", full, tt.full)
+			}
+			// drop the probe entry when it fit
+			idx.truncate(uint64(written))
+
+			// the entries are kept after reopen and the file is trimmed to them
+			if err := idx.Close(); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(written) * int64(entWidth); fi.Size() != want {
+				t.Fatalf("file size after close: got %d; want %d", fi.Size(), want)
+			}
+
+			idx = openIndex(t, path, tt.maxBytes)
+			defer idx.Close()
+			off, pos, err := idx.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := entries[written-1]
+			if off != last.off || pos != last.pos {
+				t.Fatalf("last entry after reopen: got (%d, %d); want (%d, %d)", off, pos, last.off, last.pos)
+			}
+		})
+	}
+}
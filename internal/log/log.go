@@ -0,0 +1,245 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrOffsetOutOfRange is returned by Read when no segment has the offset
+var ErrOffsetOutOfRange = errors.New("log: offset out of range")
+
+// Log is the append only commit log. records are written to the active segment and
+// the new segment is created when it is full, the segments are read back from the
+// directory on restart
+type Log struct {
+	mu sync.RWMutex
+
+	Dir    string
+	Config Config
+
+	activeSegment *segment
+	segments      []*segment
+}
+
+// NewLog opens the log in the directory, the zero size limits of the config are
+// replaced with the defaults. SyncGroup without the interval syncs on the default
+// interval, so the appends waiting for the sync don't wait for SyncBytes forever
+func NewLog(dir string, c Config) (*Log, error) {
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = defaultMaxStoreBytes
+	}
+	if c.Segment.MaxIndexBytes == 0 {
+		c.Segment.MaxIndexBytes = defaultMaxIndexBytes
+	}
+	if c.Store.Sync == SyncGroup && c.Store.SyncInterval == 0 {
+		c.Store.SyncInterval = defaultSyncInterval
+	}
+	l := &Log{
+		Dir:    dir,
+		Config: c,
+	}
+
+	return l, l.setup()
+}
+
+// setup opens the existing segments in order of their base offset, the last
+// one is the active segment
+func (l *Log) setup() error {
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	var baseOffsets []uint64
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".store" {
+			continue
+		}
+		offStr := strings.TrimSuffix(file.Name(), ".store")
+		off, err := strconv.ParseUint(offStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		baseOffsets = append(baseOffsets, off)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	for _, off := range baseOffsets {
+		if err = l.newSegment(off); err != nil {
+			return err
+		}
+	}
+	if l.segments == nil {
+		return l.newSegment(l.Config.Segment.InitialOffset)
+	}
+	return nil
+}
+
+// Append writes the record and returns its offset, it returns once the record
+// is as durable as the Store config asks. the full active segment is replaced
+// before the record is written, so the failed rotation fails the append which
+// didn't write anything instead of the one which did
+func (l *Log) Append(record []byte) (uint64, error) {
+	l.mu.Lock()
+	if l.activeSegment.IsMaxed() {
+		if err := l.newSegment(l.activeSegment.nextOffset); err != nil {
+			l.mu.Unlock()
+			return 0, err
+		}
+	}
+	s := l.activeSegment
+	off, end, err := s.Append(record)
+	l.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// wait for the sync without the lock so the other appends are synced with it
+	return off, s.store.waitDurable(end)
+}
+
+// Read returns the record at the offset
+func (l *Log) Read(off uint64) ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var s *segment
+	for _, segment := range l.segments {
+		if segment.baseOffset <= off && off < segment.nextOffset {
+			s = segment
+			break
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("%w: %d", ErrOffsetOutOfRange, off)
+	}
+	return s.Read(off)
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, segment := range l.segments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove closes the log and removes its directory
+func (l *Log) Remove() error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(l.Dir)
+}
+
+// Reset removes the log and creates the empty one in its place
+func (l *Log) Reset() error {
+	if err := l.Remove(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.segments = nil
+	return l.setup()
+}
+
+// LowestOffset returns the offset of the oldest record
+func (l *Log) LowestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.segments[0].baseOffset, nil
+}
+
+// HighestOffset returns the offset of the newest record, it is 0 when the log is
+// empty
+func (l *Log) HighestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	off := l.segments[len(l.segments)-1].nextOffset
+	if off == 0 {
+		return 0, nil
+	}
+	return off - 1, nil
+}
+
+// Truncate removes the segments whose records all have offsets lower than lowest,
+// the active segment is always kept
+func (l *Log) Truncate(lowest uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var segments []*segment
+	for _, s := range l.segments {
+		if s != l.activeSegment && s.nextOffset <= lowest {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	return nil
+}
+
+// DroppedBytes returns the number of bytes of the torn tails truncated when the
+// segments were opened
+func (l *Log) DroppedBytes() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var n uint64
+	for _, segment := range l.segments {
+		n += segment.store.dropped
+	}
+	return n
+}
+
+// Reader returns the reader of the raw store data of all the segments in order,
+// the records are in the framing written by the store
+func (l *Log) Reader() io.Reader {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	readers := make([]io.Reader, len(l.segments))
+	for i, segment := range l.segments {
+		readers[i] = &originReader{segment.store, 0}
+	}
+	return io.MultiReader(readers...)
+}
+
+// originReader reads the store from the start
+type originReader struct {
+	*store
+	off int64
+}
+
+func (o *originReader) Read(p []byte) (int, error) {
+	n, err := o.ReadAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+func (l *Log) newSegment(off uint64) error {
+	s, err := newSegment(l.Dir, off, l.Config)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, s)
+	l.activeSegment = s
+	return nil
+}
@@ -0,0 +1,256 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogSegments(t *testing.T) {
+	tests := []struct {
+		name          string
+		initialOffset uint64
+		records       int
+		perSegment    int
+	}{
+		{name: "single segment", initialOffset: 0, records: 2, perSegment: 3},
+		{name: "across segments", initialOffset: 0, records: 10, perSegment: 3},
+		{name: "initial offset", initialOffset: 100, records: 7, perSegment: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxIndexBytes = uint64(tt.perSegment) * entWidth
+			c.Segment.InitialOffset = tt.initialOffset
+
+			l, err := NewLog(dir, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.records; i++ {
+				off, err := l.Append([]byte(fmt.Sprintf("record %d", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tt.initialOffset + uint64(i); off != want {
+					t.Fatalf("offset: got %d; want %d", off, want)
+				}
+			}
+
+			check := func(l *Log) {
+				t.Helper()
+				// the full segment is replaced by the next append
+				if want := (tt.records + tt.perSegment - 1) / tt.perSegment; len(l.segments) != want {
+					t.Fatalf("segments: got %d; want %d", len(l.segments), want)
+				}
+				for i := 0; i < tt.records; i++ {
+					got, err := l.Read(tt.initialOffset + uint64(i))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := fmt.Sprintf("record %d", i); !bytes.Equal(got, []byte(want)) {
+						t.Fatalf("record %d: got %q; want %q", i, got, want)
+					}
+				}
+				_, err := l.Read(tt.initialOffset + uint64(tt.records))
+				if !errors.Is(err, ErrOffsetOutOfRange) {
+					t.Fatalf("read past the end: got %v; want ErrOffsetOutOfRange", err)
+				}
+				lowest, _ := l.LowestOffset()
+				if lowest != tt.initialOffset {
+					t.Fatalf("lowest offset: got %d; want %d", lowest, tt.initialOffset)
+				}
+				highest, _ := l.HighestOffset()
+				if want := tt.initialOffset + uint64(tt.records) - 1; highest != want {
+					t.Fatalf("highest offset: got %d; want %d", highest, want)
+				}
+			}
+			check(l)
+
+			// the segments are loaded back in order
+			if err = l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			l, err = NewLog(dir, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			check(l)
+		})
+	}
+}
+
+func TestLogTruncate(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	l, err := NewLog(t.TempDir(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err = l.Append([]byte("record")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.Truncate(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Read(1); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Fatalf("read of removed offset: got %v; want ErrOffsetOutOfRange", err)
+	}
+	lowest, _ := l.LowestOffset()
+	if lowest != 2 {
+		t.Fatalf("lowest offset: got %d; want 2", lowest)
+	}
+	if _, err = l.Read(4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		sync        SyncPolicy
+		interval    time.Duration
+		bytes       uint64
+		wait        bool
+		appends     int
+		wantSynced  bool // every append returns synced
+		wantNothing bool // nothing is synced after the appends
+	}{
+		{name: "os", sync: SyncOS, appends: 3, wantNothing: true},
+		{name: "always", sync: SyncAlways, appends: 3, wantSynced: true},
+		{name: "group without wait", sync: SyncGroup, interval: time.Hour, appends: 3, wantNothing: true},
+		{name: "group with wait", sync: SyncGroup, interval: 5 * time.Millisecond, wait: true, appends: 3, wantSynced: true},
+		{name: "group by bytes", sync: SyncGroup, interval: time.Hour, bytes: 1, appends: 3, wantSynced: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{}
+			c.Store.Sync = tt.sync
+			c.Store.SyncInterval = tt.interval
+			c.Store.SyncBytes = tt.bytes
+			c.Store.WaitForSync = tt.wait
+			l, err := NewLog(t.TempDir(), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			s := l.activeSegment.store
+			for i := 0; i < tt.appends; i++ {
+				if _, err = l.Append([]byte("record")); err != nil {
+					t.Fatal(err)
+				}
+				// the record is always handed to the OS
+				fi, err := os.Stat(s.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if uint64(fi.Size()) != s.size {
+					t.Fatalf("file size: got %d; want %d", fi.Size(), s.size)
+				}
+				if tt.wantSynced && s.syncedSize() < s.size {
+					t.Fatalf("append %d returned before sync: synced %d of %d", i, s.syncedSize(), s.size)
+				}
+			}
+			if tt.wantNothing && s.syncedSize() != 0 {
+				t.Fatalf("synced: got %d; want 0", s.syncedSize())
+			}
+		})
+	}
+}
+
+func TestLogGroupCommit(t *testing.T) {
+	c := Config{}
+	c.Store.Sync = SyncGroup
+	c.Store.SyncInterval = 10 * time.Millisecond
+	c.Store.WaitForSync = true
+	// small segments so the appends also rotate while others wait for the sync
+	c.Segment.MaxIndexBytes = 16 * entWidth
+	l, err := NewLog(t.TempDir(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const appends = 100
+	offsets := make([]uint64, appends)
+	errs := make(chan error, appends)
+
+	var wg sync.WaitGroup
+	for i := 0; i < appends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			off, err := l.Append([]byte(fmt.Sprintf("record %d", i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			offsets[i] = off
+			// the record is synced when the append returns
+			s := segmentOf(l, off)
+			if s == nil {
+				errs <- fmt.Errorf("no segment for offset %d", off)
+				return
+			}
+			_, pos, err := s.index.Read(int64(off - s.baseOffset))
+			if err != nil {
+				errs <- err
+				return
+			}
+			end, err := s.store.next(pos)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if s.store.syncedSize() < end {
+				errs <- fmt.Errorf("append of offset %d returned before sync", off)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// every waiter got the offset of its own record
+	seen := make(map[uint64]bool)
+	for i, off := range offsets {
+		if seen[off] {
+			t.Fatalf("offset %d returned twice", off)
+		}
+		seen[off] = true
+		got, err := l.Read(off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("record %d", i); string(got) != want {
+			t.Fatalf("offset %d: got %q; want %q", off, got, want)
+		}
+	}
+}
+
+// segmentOf returns the segment of the offset
+func segmentOf(l *Log, off uint64) *segment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		if s.baseOffset <= off && off < s.nextOffset {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd
+
+package log
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mmap maps the first size bytes of the file into memory, writes to the
+// returned slice are written to the file
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// msync writes the changes of the mapped memory to the file
+func msync(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// writeEntry is a no-op, the entry written to the shared mapping is already in
+// the page cache of the file
+func writeEntry(f *os.File, b []byte, pos uint64) error {
+	return nil
+}
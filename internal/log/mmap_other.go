@@ -0,0 +1,33 @@
+//go:build !(linux || darwin || freebsd)
+
+package log
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the first size bytes of the file into memory on the platforms
+// without mmap, the entries are written back to the file by writeEntry
+func mmap(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+func munmap(b []byte) error {
+	return nil
+}
+
+// msync is a no-op, every entry is written to the file when it is appended
+func msync(b []byte) error {
+	return nil
+}
+
+// writeEntry writes the entry at pos of the index to the file
+func writeEntry(f *os.File, b []byte, pos uint64) error {
+	_, err := f.WriteAt(b[pos:pos+entWidth], int64(pos))
+	return err
+}
@@ -0,0 +1,149 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// segment pairs the store with its index. the files are named after the offset
+// of the first record in the segment
+type segment struct {
+	store                  *store
+	index                  *index
+	baseOffset, nextOffset uint64
+	config                 Config
+}
+
+func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	s := &segment{
+		baseOffset: baseOffset,
+		config:     c,
+	}
+	storeFile, err := os.OpenFile(
+		filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.store, err = newStore(storeFile, c); err != nil {
+		storeFile.Close()
+		return nil, err
+	}
+	indexFile, err := os.OpenFile(
+		filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		os.O_RDWR|os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		s.store.Close()
+		return nil, err
+	}
+	if s.index, err = newIndex(indexFile, c); err != nil {
+		indexFile.Close()
+		s.store.Close()
+		return nil, err
+	}
+	if err = s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.nextOffset = baseOffset + s.index.entries()
+	return s, nil
+}
+
+// recover makes the index match the store after the segment was not closed
+// cleanly. the entries which don't point into the store are dropped and the
+// records of the store which are missing in the index are added
+func (s *segment) recover() error {
+	// the valid entries are always the prefix of the index, the relative offsets
+	// are sequential and the positions are inside the store
+	n := sort.Search(int(s.index.entries()), func(i int) bool {
+		off, pos, err := s.index.Read(int64(i))
+		return err != nil || uint64(off) != uint64(i) || pos >= s.store.size
+	})
+	s.index.truncate(uint64(n))
+
+	var pos uint64
+	if n > 0 {
+		_, last, err := s.index.Read(-1)
+		if err != nil {
+			return err
+		}
+		if pos, err = s.store.next(last); err != nil {
+			return err
+		}
+	}
+
+	// only the record headers are read, corrupted payloads are still indexed
+	// and reported when they are read
+	for pos < s.store.size {
+		if err := s.index.Write(uint32(s.index.entries()), pos); err != nil {
+			return err
+		}
+		next, err := s.store.next(pos)
+		if err != nil {
+			return err
+		}
+		pos = next
+	}
+	return nil
+}
+
+// Append writes the record and returns its offset and the end of the record in
+// the store, which is passed to store.waitDurable
+func (s *segment) Append(record []byte) (offset uint64, end uint64, err error) {
+	cur := s.nextOffset
+	n, pos, err := s.store.Append(record)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = s.index.Write(
+		// index offsets are relative to base offset
+		uint32(s.nextOffset-s.baseOffset),
+		pos,
+	); err != nil {
+		return 0, 0, err
+	}
+	s.nextOffset++
+	return cur, pos + n, nil
+}
+
+// Read returns the record at the absolute offset
+func (s *segment) Read(off uint64) ([]byte, error) {
+	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Read(pos)
+}
+
+// IsMaxed reports whether the store or the index is full
+func (s *segment) IsMaxed() bool {
+	return s.store.size >= s.config.Segment.MaxStoreBytes ||
+		s.index.size+entWidth > s.config.Segment.MaxIndexBytes
+}
+
+// Remove closes the segment and removes its files
+func (s *segment) Remove() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.index.Name()); err != nil {
+		return err
+	}
+	return os.Remove(s.store.Name())
+}
+
+// Close closes the index and the store, the store is closed even if closing the
+// index fails
+func (s *segment) Close() error {
+	err := s.index.Close()
+	if serr := s.store.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
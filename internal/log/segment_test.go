@@ -0,0 +1,157 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegment(t *testing.T) {
+	record := []byte("hello world")
+	width := headerWidth + uint64(len(record))
+
+	tests := []struct {
+		name          string
+		maxStoreBytes uint64
+		maxIndexBytes uint64
+		appends       int
+	}{
+		{name: "maxed by index", maxStoreBytes: 1024, maxIndexBytes: 3 * entWidth, appends: 3},
+		{name: "maxed by store", maxStoreBytes: 3 * width, maxIndexBytes: 1024, appends: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxStoreBytes = tt.maxStoreBytes
+			c.Segment.MaxIndexBytes = tt.maxIndexBytes
+
+			s, err := newSegment(dir, 16, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.nextOffset != 16 {
+				t.Fatalf("next offset: got %d; want 16", s.nextOffset)
+			}
+
+			for i := 0; i < tt.appends; i++ {
+				if s.IsMaxed() {
+					t.Fatalf("maxed after %d appends", i)
+				}
+				off, _, err := s.Append([]byte(fmt.Sprintf("%s %d", record[:len(record)-2], i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if off != 16+uint64(i) {
+					t.Fatalf("offset: got %d; want %d", off, 16+uint64(i))
+				}
+			}
+			if !s.IsMaxed() {
+				t.Fatal("segment is not maxed")
+			}
+
+			// the index is rebuilt from the store when it was lost
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Remove(s.index.Name()); err != nil {
+				t.Fatal(err)
+			}
+			s, err = newSegment(dir, 16, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.nextOffset != 16+uint64(tt.appends) {
+				t.Fatalf("next offset after reopen: got %d; want %d", s.nextOffset, 16+tt.appends)
+			}
+			for i := 0; i < tt.appends; i++ {
+				got, err := s.Read(16 + uint64(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []byte(fmt.Sprintf("%s %d", record[:len(record)-2], i))
+				if !bytes.Equal(got, want) {
+					t.Fatalf("record %d: got %q; want %q", i, got, want)
+				}
+			}
+			if _, err = s.Read(16 + uint64(tt.appends)); err != io.EOF {
+				t.Fatalf("read past the end: got %v; want io.EOF", err)
+			}
+			if err = s.Remove(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSegmentOpenError(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("open files can't be counted")
+	}
+
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dir string)
+	}{
+		{
+			name: "index can't be opened",
+			damage: func(t *testing.T, dir string) {
+				if err := os.Mkdir(filepath.Join(dir, "0.index"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "store is corrupted",
+			damage: func(t *testing.T, dir string) {
+				length := make([]byte, lenWidth)
+				enc.PutUint64(length, 1<<20)
+				f, err := os.OpenFile(filepath.Join(dir, "0.store"), os.O_RDWR, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				writeAt(t, f, length, 0)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			c.Segment.MaxIndexBytes = 1024
+
+			s, err := newSegment(dir, 0, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if _, _, err = s.Append([]byte("record")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Remove(filepath.Join(dir, "0.index")); err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, dir)
+
+			before, _ := os.ReadDir("/proc/self/fd")
+			if _, err = newSegment(dir, 0, c); err == nil {
+				t.Fatal("segment opened")
+			}
+			after, _ := os.ReadDir("/proc/self/fd")
+			if len(after) != len(before) {
+				t.Fatalf("open files: got %d; want %d", len(after), len(before))
+			}
+		})
+	}
+}
@@ -3,73 +3,366 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 var (
 	enc = binary.BigEndian
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
+// each record is written as the length of the payload, the crc32 of the length
+// and the payload, followed by the payload
 const (
-	lenWidth = 8
+	lenWidth    = 8
+	crcWidth    = 4
+	headerWidth = lenWidth + crcWidth
 )
 
+// CorruptionError is returned by Read when the record doesn't match its checksum
+// or ends past the end of the store
+type CorruptionError struct {
+	Pos    uint64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("log: corrupted record at position %d: %s", e.Pos, e.Reason)
+}
+
 type store struct {
 	*os.File
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+
+	// bytes of the torn tail truncated when the store was opened
+	dropped uint64
+
+	policy   SyncPolicy
+	interval time.Duration
+	bytes    uint64
+	wait     bool
+
+	// size of the store which is synced to the disk, cond is signalled when it
+	// changes. the failed sync is kept in syncErr and returned by the appends
+	// after it because it is not known which writes reached the disk
+	synced  uint64
+	syncErr error
+	cond    *sync.Cond
+	// only one sync runs at a time, the appends during it are synced together
+	// by the next one
+	syncMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-func newStore(f *os.File) (*store, error) {
+func newStore(f *os.File, c Config) (*store, error) {
 	fi, err := os.Stat(f.Name())
 	if err != nil {
 		return nil, err
 	}
-	size := uint64(fi.Size())
-	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-	}, nil
+	size, dropped, err := recoverStore(f, uint64(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		File:     f,
+		size:     size,
+		buf:      bufio.NewWriter(f),
+		policy:   c.Store.Sync,
+		interval: c.Store.SyncInterval,
+		bytes:    c.Store.SyncBytes,
+		wait:     c.Store.WaitForSync,
+		synced:   size,
+		dropped:  dropped,
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	if s.policy == SyncGroup && s.interval > 0 {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// syncLoop syncs the group of appends on every interval until the store is closed
+func (s *store) syncLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// the error is returned by the following appends
+			s.sync()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sync writes the buffer to the file and syncs the file to the disk, nothing is
+// done if all the appends are already synced
+func (s *store) sync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	s.mu.Lock()
+	if s.syncErr != nil || s.synced >= s.size {
+		err := s.syncErr
+		s.mu.Unlock()
+		return err
+	}
+	if err := s.buf.Flush(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	target := s.size
+	s.mu.Unlock()
+
+	// appends can continue while the file is synced
+	err := s.File.Sync()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.syncErr = err
+	} else if target > s.synced {
+		s.synced = target
+	}
+	s.cond.Broadcast()
+	return err
 }
 
+// waitSynced blocks until the store is synced up to end
+func (s *store) waitSynced(end uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.synced < end && s.syncErr == nil {
+		s.cond.Wait()
+	}
+	return s.syncErr
+}
+
+// recoverStore scans the records and truncates the torn tail left by the crash
+// during the write, it returns the size of the store and the number of bytes
+// dropped. everything after the last record which matches its checksum is the
+// tail, like the partial record or the zeros of the extended file. the corrupted
+// records in the middle are kept so the records after them can still be read.
+// the length running past the end of file is only the torn tail when no valid
+// record follows it, otherwise the length itself is corrupted and the records
+// after it can't be found, so *CorruptionError is returned instead of dropping them
+func recoverStore(f *os.File, size uint64) (uint64, uint64, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, 0, int64(size)))
+	header := make([]byte, headerWidth)
+
+	var pos, valid uint64
+	for pos+headerWidth <= size {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, 0, err
+		}
+		n := enc.Uint64(header[:lenWidth])
+		if n > size-pos-headerWidth {
+			found, err := validRecordAfter(f, pos+1, size)
+			if err != nil {
+				return 0, 0, err
+			}
+			if found {
+				return 0, 0, &CorruptionError{Pos: pos, Reason: "record length past end of store followed by valid records"}
+			}
+			break
+		}
+
+		h := crc32.New(crcTable)
+		h.Write(header[:lenWidth])
+		if _, err := io.CopyN(h, r, int64(n)); err != nil {
+			return 0, 0, err
+		}
+		pos += headerWidth + n
+		if h.Sum32() == enc.Uint32(header[lenWidth:]) {
+			valid = pos
+		}
+	}
+
+	if valid < size {
+		if err := f.Truncate(int64(valid)); err != nil {
+			return 0, 0, err
+		}
+		if err := f.Sync(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return valid, size - valid, nil
+}
+
+// validRecordAfter reports whether any position from from to the end of file
+// holds the record which matches its checksum. it is only called for the bad
+// length, so the rest of the store is read into memory
+func validRecordAfter(f *os.File, from, size uint64) (bool, error) {
+	if from >= size {
+		return false, nil
+	}
+	b := make([]byte, size-from)
+	if _, err := f.ReadAt(b, int64(from)); err != nil {
+		return false, err
+	}
+	for i := uint64(0); i+headerWidth <= uint64(len(b)); i++ {
+		n := enc.Uint64(b[i : i+lenWidth])
+		if n > uint64(len(b))-i-headerWidth {
+			continue
+		}
+		header := b[i : i+headerWidth]
+		if checksum(header, b[i+headerWidth:i+headerWidth+n]) == enc.Uint32(header[lenWidth:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func checksum(header, p []byte) uint32 {
+	crc := crc32.Update(0, crcTable, header[:lenWidth])
+	return crc32.Update(crc, crcTable, p)
+}
+
+// Append writes the record to the OS and returns its size and position, the
+// record is made durable by waitDurable
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
+	_, n, pos, err = s.write(p)
+	return n, pos, err
+}
+
+// waitDurable syncs the store up to end as the policy says. with SyncAlways, or
+// SyncGroup and WaitForSync, it returns once the store is synced up to end. it
+// is called without holding the log lock, so the concurrent appends can be
+// synced together
+func (s *store) waitDurable(end uint64) error {
+	switch s.policy {
+	case SyncAlways:
+		if s.syncedSize() >= end {
+			return nil
+		}
+		return s.sync()
+	case SyncGroup:
+		if s.bytes > 0 && end-s.syncedSize() >= s.bytes {
+			if err := s.sync(); err != nil {
+				return err
+			}
+		}
+		if s.wait {
+			return s.waitSynced(end)
+		}
+	}
+	return nil
+}
+
+// write writes the record and returns the size of the store after it
+func (s *store) write(p []byte) (end uint64, n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.syncErr != nil {
+		return 0, 0, 0, s.syncErr
+	}
+
 	pos = s.size
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
-		return 0, 0, err
+	header := make([]byte, headerWidth)
+	enc.PutUint64(header[:lenWidth], uint64(len(p)))
+	enc.PutUint32(header[lenWidth:], checksum(header, p))
+	if _, err := s.buf.Write(header); err != nil {
+		return 0, 0, 0, err
 	}
 
 	w, err := s.buf.Write(p)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
-	w += lenWidth
+	w += headerWidth
 	s.size += uint64(w)
-	return uint64(w), pos, nil
+
+	// hand the record to the OS so it survives the crash of the process
+	if err := s.buf.Flush(); err != nil {
+		return 0, 0, 0, err
+	}
+	return s.size, uint64(w), pos, nil
 }
 
+func (s *store) syncedSize() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncErr != nil {
+		return 0
+	}
+	return s.synced
+}
+
+// Read returns the payload of the record at pos, *CorruptionError is returned if
+// the record is damaged
 func (s *store) Read(pos uint64) ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.buf.Flush(); err != nil {
 		return nil, err
 	}
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	header, err := s.readHeader(pos)
+	if err != nil {
 		return nil, err
 	}
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	n := enc.Uint64(header[:lenWidth])
+	b := make([]byte, n)
+	if _, err := s.File.ReadAt(b, int64(pos+headerWidth)); err != nil {
 		return nil, err
 	}
+	if checksum(header, b) != enc.Uint32(header[lenWidth:]) {
+		return nil, &CorruptionError{Pos: pos, Reason: "checksum mismatch"}
+	}
 	return b, nil
 }
 
+// next returns the position of the record after the one at pos without reading
+// the payload, the buffer must be flushed
+func (s *store) next(pos uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	header, err := s.readHeader(pos)
+	if err != nil {
+		return 0, err
+	}
+	return pos + headerWidth + enc.Uint64(header[:lenWidth]), nil
+}
+
+// readHeader reads the header of the record at pos and checks that the record
+// ends inside the store, the caller must hold the mutex
+func (s *store) readHeader(pos uint64) ([]byte, error) {
+	if pos+headerWidth > s.size {
+		return nil, &CorruptionError{Pos: pos, Reason: "header past end of store"}
+	}
+	header := make([]byte, headerWidth)
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, &CorruptionError{Pos: pos, Reason: "header past end of file"}
+		}
+		return nil, err
+	}
+	if enc.Uint64(header[:lenWidth]) > s.size-pos-headerWidth {
+		return nil, &CorruptionError{Pos: pos, Reason: "record past end of store"}
+	}
+	return header, nil
+}
+
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -79,12 +372,26 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// Close stops the group sync and syncs the remaining appends unless the policy
+// is SyncOS
 func (s *store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	var err error
+	if s.policy != SyncOS {
+		err = s.sync()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	err := s.buf.Flush()
-	if err != nil {
-		return err
+	if flushErr := s.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := s.File.Close(); err == nil {
+		err = closeErr
 	}
-	return s.File.Close()
+	return err
 }
@@ -0,0 +1,181 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openStore(t *testing.T, path string) (*store, error) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newStore(f, Config{})
+	if err != nil {
+		f.Close()
+	}
+	return s, err
+}
+
+func TestStoreRecover(t *testing.T) {
+	records := [][]byte{
+		[]byte("first record"),
+		[]byte("second record"),
+		[]byte("third record"),
+	}
+	width := func(i int) int64 {
+		return int64(headerWidth) + int64(len(records[i]))
+	}
+	// position of each record and the size of the intact store
+	positions := []int64{0, width(0), width(0) + width(1)}
+	full := positions[2] + width(2)
+
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, f *os.File)
+		size    int64  // size of the store after recovery
+		dropped uint64 // bytes truncated by recovery
+		corrupt []int  // records which read as *CorruptionError
+		openErr bool   // recovery returns *CorruptionError
+	}{
+		{
+			name:   "intact",
+			damage: func(t *testing.T, f *os.File) {},
+			size:   full,
+		},
+		{
+			name: "torn tail",
+			damage: func(t *testing.T, f *os.File) {
+				// the header and half of the payload of the new record
+				header := make([]byte, headerWidth)
+				enc.PutUint64(header, 100)
+				writeAt(t, f, append(header, bytes.Repeat([]byte("x"), 50)...), full)
+			},
+			size:    full,
+			dropped: uint64(headerWidth) + 50,
+		},
+		{
+			name: "partial header",
+			damage: func(t *testing.T, f *os.File) {
+				writeAt(t, f, []byte{0, 0, 0}, full)
+			},
+			size:    full,
+			dropped: 3,
+		},
+		{
+			name: "zeros of extended file",
+			damage: func(t *testing.T, f *os.File) {
+				writeAt(t, f, make([]byte, 64), full)
+			},
+			size:    full,
+			dropped: 64,
+		},
+		{
+			name: "crc mismatch on last record",
+			damage: func(t *testing.T, f *os.File) {
+				writeAt(t, f, []byte("X"), full-1)
+			},
+			size:    positions[2],
+			dropped: uint64(width(2)),
+		},
+		{
+			name: "crc mismatch in the middle",
+			damage: func(t *testing.T, f *os.File) {
+				writeAt(t, f, []byte("X"), positions[1]+int64(headerWidth))
+			},
+			size:    full,
+			corrupt: []int{1},
+		},
+		{
+			name: "bad length in the middle",
+			damage: func(t *testing.T, f *os.File) {
+				length := make([]byte, lenWidth)
+				enc.PutUint64(length, 1<<20)
+				writeAt(t, f, length, positions[1])
+			},
+			openErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "0.store")
+			s, err := openStore(t, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if _, _, err = s.Append(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, f)
+			f.Close()
+
+			s, err = openStore(t, path)
+			var corrupted *CorruptionError
+			if tt.openErr {
+				if !errors.As(err, &corrupted) {
+					t.Fatalf("open: got %v; want *CorruptionError", err)
+				}
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Size() != full {
+					t.Fatalf("store was truncated to %d bytes", fi.Size())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if int64(s.size) != tt.size {
+				t.Fatalf("size: got %d; want %d", s.size, tt.size)
+			}
+			if s.dropped != tt.dropped {
+				t.Fatalf("dropped: got %d; want %d", s.dropped, tt.dropped)
+			}
+			for i, pos := range positions {
+				if pos >= tt.size {
+					break
+				}
+				got, err := s.Read(uint64(pos))
+				if slices.Contains(tt.corrupt, i) {
+					if !errors.As(err, &corrupted) {
+						t.Fatalf("record %d: got %v; want *CorruptionError", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, records[i]) {
+					t.Fatalf("record %d: got %q; want %q", i, got, records[i])
+				}
+			}
+		})
+	}
+}
+
+func writeAt(t *testing.T, f *os.File, b []byte, off int64) {
+	t.Helper()
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
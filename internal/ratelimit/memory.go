@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// MemoryLimiter keeps a token bucket for each key in the memory of the process,
+// the limits are not shared between the instances of the application
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	done    chan struct{}
+	once    sync.Once
+}
+
+// define client struct which will hold rate limiter and last seen time
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemoryLimiter returns the limiter and launch a background go routine which
+// removes the clients not seen for idle time, every interval
+func NewMemoryLimiter(interval, idle time.Duration) *MemoryLimiter {
+	l := &MemoryLimiter{
+		clients: make(map[string]*client),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.cleanup(idle)
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	return l
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// check if the key already exists in the map. if not present
+	// add it client map with new rateLimiter
+	if _, found := l.clients[key]; !found {
+		l.clients[key] = &client{
+			limiter: rate.NewLimiter(rate.Limit(rule.RPS), rule.Burst),
+		}
+	}
+
+	now := time.Now()
+	c := l.clients[key]
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+	return newResult(allowed, c.limiter.TokensAt(now), rule), nil
+}
+
+// cleanup deletes the clients which haven't been seen within the idle time
+func (l *MemoryLimiter) cleanup(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, c := range l.clients {
+		if time.Since(c.lastSeen) > idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Close stops the cleanup go routine
+func (l *MemoryLimiter) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
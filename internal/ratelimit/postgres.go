@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PostgresLimiter keeps the token buckets in the rate_limits table so the limits
+// are shared by all the instances of the application using the same database
+type PostgresLimiter struct {
+	DB   *sql.DB
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPostgresLimiter returns the limiter and launch a background go routine which
+// removes the buckets not updated for idle time, every interval
+func NewPostgresLimiter(db *sql.DB, interval, idle time.Duration) *PostgresLimiter {
+	l := &PostgresLimiter{
+		DB:   db,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// the error is ignored, the buckets are removed on the next tick
+				_ = l.cleanup(idle)
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	return l
+}
+
+// refill is tokens in the bucket after adding the tokens for the time since last update
+const refill = `LEAST($3::double precision, rate_limits.tokens + EXTRACT(EPOCH FROM (now() - rate_limits.updated_at))::double precision * $2::double precision)`
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	// the bucket is refilled and a token is taken in a single statement, the row lock
+	// of the upsert makes it safe with concurrent requests from other instances.
+	// new bucket starts full, so the first request leaves burst-1 tokens
+	query := fmt.Sprintf(`
+    INSERT INTO rate_limits (key, tokens, allowed, updated_at)
+    VALUES ($1, $3::double precision - 1, $3::double precision >= 1, now())
+    ON CONFLICT (key) DO UPDATE SET
+        tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+        allowed = %[1]s >= 1,
+        updated_at = now()
+    RETURNING tokens, allowed`, refill)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+
+	err := l.DB.QueryRowContext(ctx, query, key, rule.RPS, rule.Burst).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(allowed, tokens, rule), nil
+}
+
+// cleanup deletes the buckets which haven't been updated within the idle time
+func (l *PostgresLimiter) cleanup(idle time.Duration) error {
+	query := `DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := l.DB.ExecContext(ctx, query, idle.Seconds())
+	return err
+}
+
+// Close stops the cleanup go routine
+func (l *PostgresLimiter) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
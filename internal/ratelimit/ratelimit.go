@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rule is the token bucket configuration, RPS is the rate at which tokens are
+// added and Burst is the maximum number of tokens in the bucket
+type Rule struct {
+	RPS   float64
+	Burst int
+}
+
+// Result of the Allow call with the information for the RateLimit headers
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until the next request will be allowed, only set if not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the request for the key is allowed under the rule.
+// Close stops the background cleanup of the limiter
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (Result, error)
+	Close() error
+}
+
+// newResult calculates the result from the tokens left in the bucket after the request
+func newResult(allowed bool, tokens float64, rule Rule) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     rule.Burst,
+		Remaining: max(0, int(math.Floor(tokens))),
+		Reset:     refillTime(float64(rule.Burst)-tokens, rule.RPS),
+	}
+	if !allowed {
+		result.RetryAfter = refillTime(1-tokens, rule.RPS)
+	}
+	return result
+}
+
+// refillTime returns the time to add the tokens to the bucket with the rps rate
+func refillTime(tokens float64, rps float64) time.Duration {
+	if tokens <= 0 || rps <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / rps * float64(time.Second))
+}
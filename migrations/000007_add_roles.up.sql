@@ -0,0 +1,64 @@
+CREATE TABLE IF NOT EXISTS
+    roles (id bigserial PRIMARY KEY, name text UNIQUE NOT NULL);
+
+CREATE TABLE IF NOT EXISTS
+    roles_permissions (
+        role_id bigint NOT NULL REFERENCES roles ON
+        DELETE CASCADE,
+        permission_id bigint NOT NULL REFERENCES permissions ON
+        DELETE CASCADE,
+        PRIMARY KEY (role_id, permission_id)
+    );
+
+CREATE TABLE IF NOT EXISTS
+    users_roles (
+        user_id bigint NOT NULL REFERENCES users ON
+        DELETE CASCADE,
+        role_id bigint NOT NULL REFERENCES roles ON
+        DELETE CASCADE,
+        PRIMARY KEY (user_id, role_id)
+    );
+
+-- Add the permission for managing users grants.
+INSERT INTO
+    permissions (code)
+VALUES
+    ('users:admin');
+
+-- Add the roles and the permissions they bundle.
+INSERT INTO
+    roles (name)
+VALUES
+    ('viewer'),
+    ('editor'),
+    ('admin');
+
+INSERT INTO
+    roles_permissions (role_id, permission_id)
+SELECT
+    roles.id,
+    permissions.id
+FROM
+    roles,
+    permissions
+WHERE
+    (roles.name = 'viewer' AND permissions.code = 'movies:read')
+    OR (roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+    OR (roles.name = 'admin' AND permissions.code IN ('movies:read', 'movies:write', 'users:admin'));
+
+-- Give the users registered before the roles the default role, so they can still
+-- read the movies.
+INSERT INTO
+    users_roles (user_id, role_id)
+SELECT
+    users.id,
+    roles.id
+FROM
+    users,
+    roles
+WHERE
+    roles.name = 'viewer' ON CONFLICT DO NOTHING;
+
+-- Nobody is admin yet, grant the admin role to the first user with
+-- `make db/grant-admin email=<email>`, the other users can be managed through
+-- /v1/admin after that.
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+key text PRIMARY KEY,
+tokens double precision NOT NULL,
+allowed bool NOT NULL,
+updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);